@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/devkcud/mess/internal/core"
+	"github.com/devkcud/mess/pkg/mess"
 	"github.com/devkcud/mess/pkg/messlog"
+	"github.com/devkcud/mess/pkg/node"
 )
 
 func main() {
@@ -20,9 +22,25 @@ func main() {
 	cli := core.NewCLI()
 
 	base := cli.StringP("base", "b", dir, "base working directory")
+	root := cli.String("root", "", "build below this directory as if it were / (like DESTDIR); the base defaults to /")
+	jail := cli.Bool("jail", false, "reject tokens that put anything outside the base directory, including through symlinks")
+	rootUsers := cli.Bool("root-users", false, "with --root, resolve owners with the etc/passwd and etc/group of the root")
 	dryRun := cli.BoolP("dry", "d", false, "simulate file/directory creation without writing anything on disk")
-	echo := cli.BoolP("echo", "e", false, "print shell commands instead of creating anything")
+	long := cli.BoolP("long", "l", false, "dry run with the mode, owner, elevation and status of every node")
+	echo := cli.BoolP("echo", "e", false, "print commands instead of creating anything")
+	dialect := cli.String("dialect", string(node.DialectSh), "what --echo writes commands for (sh | bash | fish | powershell | cmd | make | dockerfile)")
+	script := cli.Bool("script", false, "print a standalone shell script that creates everything")
+	format := cli.StringP("format", "f", "", "print the plan in another format (ansible | cloud-init | nix | tmpfiles | install | dot | mermaid | html)")
+	archive := cli.StringP("archive", "a", "", "write everything into an archive (.tar, .tar.gz, .zip, .cpio, .cpio.gz) instead of the disk")
+	ociLayer := cli.String("oci-layer", "", "write everything as a layer of an OCI image layout in this directory instead of the disk")
 	printJson := cli.BoolP("json", "j", false, "print file/directory list as json")
+	jsonFlat := cli.Bool("json-flat", false, "print file/directory list as a flat json list")
+	jsonSchema := cli.Bool("json-schema", false, "print the JSON Schema of the json output and exit")
+	events := cli.String("events", "", "stream build progress to stdout (ndjson)")
+	sync := cli.Bool("sync", false, "flush created files and directories to disk, writing file contents through a temporary file")
+	rollback := cli.Bool("rollback", false, "remove what was created when the build fails")
+	jobs := cli.Int("jobs", 1, "how many files and directories to create at once")
+	mtime := cli.String("mtime", "", "access/modification time of created nodes (date, [@]epoch, relative like -3d, or SOURCE_DATE_EPOCH)")
 	loglevel := cli.Int("loglevel", int(messlog.LogLevelError), "logging output (0 = error | 1 = warn | 2 = info | 3 = debug | 4 = trace)")
 	help := cli.BoolP("help", "h", false, "help menu")
 
@@ -35,14 +53,41 @@ func main() {
 		cli.HelpExit(false)
 	}
 
+	if *jsonSchema {
+		os.Stdout.Write(node.JSONSchema)
+		os.Exit(0)
+	}
+
 	if len(tokens) == 0 {
 		cli.HelpExit(true)
 	}
 
 	logger := messlog.NewLogger(messlog.LogLevel(*loglevel))
 
+	if *long {
+		*dryRun = true
+	}
+
 	tokenIterStart := time.Now()
-	builder := core.NewBuilder(*base, logger, *dryRun, *echo)
+	opts := []mess.Option{mess.WithJail(*jail), mess.WithSync(*sync), mess.WithJobs(*jobs), mess.WithRollback(*rollback)}
+	if *root != "" {
+		// These print paths for the host to run or apply, which can't say
+		// they are below the root.
+		if *echo || *script || *format != "" {
+			log.Fatalf("--root can't be used with --echo, --script or --format")
+		}
+		if !cli.Changed("base") {
+			*base = "/"
+		}
+		opts = append(opts, mess.WithRoot(*root, *rootUsers))
+	}
+
+	echoDialect := ""
+	if *echo {
+		echoDialect = *dialect
+	}
+
+	builder := core.NewBuilder(*base, logger, *dryRun, echoDialect, opts...)
 	for i, token := range tokens {
 		iterStart := time.Now()
 
@@ -55,25 +100,77 @@ func main() {
 
 	logger.Trace("Ran all %d tokens in %s", len(tokens), time.Since(tokenIterStart))
 
-	if *dryRun != false || *echo != false || *printJson != false {
+	if *mtime != "" {
+		if err := builder.SetModTime(*mtime); err != nil {
+			logger.Error("Invalid --mtime %q: %v", *mtime, err)
+		}
+	}
+
+	if *events != "" {
+		if err := builder.SetEvents(*events); err != nil {
+			logger.Error("Invalid --events %q: %v", *events, err)
+		}
+	}
+
+	if *archive != "" {
+		logger.Info("Writing archive %s", *archive)
+
+		if err := builder.WriteArchive(*archive); err != nil {
+			logger.Error("Couldn't write archive: %v", err)
+		}
+	} else if *ociLayer != "" {
+		logger.Info("Writing OCI image layout %s", *ociLayer)
+
+		if err := builder.WriteOCILayout(*ociLayer); err != nil {
+			logger.Error("Couldn't write OCI image layout: %v", err)
+		}
+	} else if *dryRun != false || *echo != false || *script != false || *format != "" || *printJson != false || *jsonFlat != false {
 		logger.Info("Skipping file builds. Dry Run or Echo detected")
 
 		if *dryRun {
-			logger.Debug("Printing Dry Run tree")
-			builder.PrintDryRunTree()
+			if *long {
+				logger.Debug("Printing long Dry Run tree")
+				builder.PrintLongTree()
+			} else {
+				logger.Debug("Printing Dry Run tree")
+				builder.PrintDryRunTree()
+			}
 		}
 
 		if *echo {
-			logger.Debug("Printing Echo tree")
-			builder.PrintEchoFiles()
+			logger.Debug("Printing Echo tree as %s", *dialect)
+			if err := builder.PrintEchoFiles(); err != nil {
+				logger.Error("Couldn't print commands: %v", err)
+			}
+		}
+
+		if *script {
+			logger.Debug("Printing script")
+			if err := builder.PrintScript(); err != nil {
+				logger.Error("Couldn't print script: %v", err)
+			}
+		}
+
+		if *format != "" {
+			logger.Debug("Printing plan as %s", *format)
+			if err := builder.PrintFormat(*format); err != nil {
+				logger.Error("Couldn't print plan: %v", err)
+			}
 		}
 
 		if *printJson {
 			logger.Debug("Printing json tree")
-			if err := builder.PrintJSON(); err != nil {
+			if err := builder.PrintJSON(false); err != nil {
 				logger.Error("Couldn't print tree as json: %v", err)
 			}
 		}
+
+		if *jsonFlat {
+			logger.Debug("Printing flat json list")
+			if err := builder.PrintJSON(true); err != nil {
+				logger.Error("Couldn't print list as json: %v", err)
+			}
+		}
 	} else {
 		logger.Info("Building directories and files")
 
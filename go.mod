@@ -5,4 +5,5 @@ go 1.24.2
 require (
 	github.com/spf13/pflag v1.0.6
 	golang.org/x/sys v0.33.0
+	golang.org/x/term v0.32.0
 )
@@ -1,11 +1,12 @@
 package core
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
-	"runtime/debug"
-	"strings"
+	"os"
+	"time"
 
+	"github.com/devkcud/mess/pkg/mess"
 	"github.com/devkcud/mess/pkg/messlog"
 	"github.com/devkcud/mess/pkg/node"
 	"github.com/devkcud/mess/pkg/utils"
@@ -15,79 +16,110 @@ type builder struct {
 	logger *messlog.Logger
 
 	dryRun bool
-	echo   bool
+	echo   string
 
-	root *node.Node
+	plan *mess.Plan
+
+	mtime *time.Time
 }
 
-func NewBuilder(base string, logger *messlog.Logger, dry, echo bool) *builder {
+func NewBuilder(base string, logger *messlog.Logger, dry bool, echo string, opts ...mess.Option) *builder {
+	opts = append([]mess.Option{mess.WithBase(base), mess.WithLogger(logger)}, opts...)
+
 	return &builder{
 		logger: logger,
 		dryRun: dry,
 		echo:   echo,
-		root:   node.New(base),
+		plan:   mess.New(opts...),
 	}
 }
 
-func (b *builder) addDirectory(path string) {
-	b.logger.Info("Added directory %s", path)
-	b.root = b.root.AddDirectory(path)
+func (b *builder) ProcessToken(token string) error {
+	return b.plan.AddToken(token)
 }
 
-func (b *builder) addFile(path string) {
-	b.logger.Info("Added file %s", path)
-	b.root.AddFile(path)
+func (b *builder) SetModTime(value string) error {
+	t, err := node.ParseTime(value, time.Now())
+	if err != nil {
+		return err
+	}
+
+	b.logger.Debug("Default modification time set to %s", t)
+	b.plan.With(mess.WithModTime(t))
+	b.mtime = &t
+	return nil
 }
 
-func (b *builder) ProcessToken(token string) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			b.logger.Trace("Panic detected: %v\n%s", r, debug.Stack())
-			err = fmt.Errorf("panic occurred: %v", r)
-		}
-	}()
-
-	switch {
-	case token == "..":
-		b.logger.Debug("Rule found: ..")
-		b.root = b.root.Up()
-		b.logger.Trace("Stack tree moved up one parent: %s", token)
-
-	case strings.HasSuffix(token, utils.OSPathSeparator):
-		b.logger.Debug("Rule found: dir/")
-		b.addDirectory(token)
-		b.logger.Trace("Stack tree added one directory: %s", token)
-
-	case strings.Contains(token, utils.OSPathSeparator):
-		b.logger.Debug("Rule found: dir/file")
-		dir, file := filepath.Split(token)
-
-		cur := b.root
-		b.addDirectory(dir)
-		b.addFile(file)
-		b.root = cur
-
-		b.logger.Trace("Stack tree added one directory and one file: %s", token)
-		b.logger.Trace("`currentTree` remains intact")
-
-	default:
-		b.logger.Debug("Rule found: file")
-		b.addFile(token)
-		b.logger.Trace("Stack tree added one file: %s", token)
+// archiveTime is the timestamp of archived nodes without one of their own.
+// Unlike on disk, this includes the directories that already exist.
+func (b *builder) archiveTime() time.Time {
+	if b.mtime != nil {
+		return *b.mtime
 	}
-
-	return
+	return time.Now()
 }
 
 func (b *builder) PrintDryRunTree() {
-	b.root.Root().PrintNodeTree()
+	b.plan.Tree().PrintNodeTree()
+}
+
+func (b *builder) PrintLongTree() {
+	b.plan.Tree().PrintLongTree(utils.UseColor(os.Stdout))
+}
+
+func (b *builder) PrintEchoFiles() error {
+	dialect, err := node.ParseDialect(b.echo)
+	if err != nil {
+		return err
+	}
+
+	return b.plan.Tree().PrintCommands(dialect)
+}
+func (b *builder) PrintScript() error {
+	return b.plan.Tree().PrintScript()
+}
+
+func (b *builder) PrintFormat(name string) error {
+	format, err := node.ParseFormat(name)
+	if err != nil {
+		return err
+	}
+
+	return b.plan.Tree().PrintFormat(format)
+}
+
+func (b *builder) WriteArchive(path string) error {
+	format, err := node.ArchiveFormatFromName(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := b.plan.Base().WriteArchive(f, format, b.archiveTime()); err != nil {
+		f.Close()
+		return err
+	}
+
+	b.logger.Info("Wrote %s archive %s", format, path)
+	return f.Close()
 }
 
-func (b *builder) PrintEchoFiles() {
-	b.root.Root().PrintCommands()
+func (b *builder) WriteOCILayout(dir string) error {
+	digest, err := b.plan.Base().WriteOCILayout(dir, b.archiveTime())
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("Wrote OCI image %s to %s", digest, dir)
+	return nil
 }
-func (b *builder) PrintJSON() error {
-	j, err := b.root.Root().PrintJSON("    ")
+
+func (b *builder) PrintJSON(flat bool) error {
+	j, err := b.plan.Tree().PrintJSON("    ", flat)
 	defer fmt.Println(j)
 	if err != nil {
 		return err
@@ -95,9 +127,31 @@ func (b *builder) PrintJSON() error {
 	return nil
 }
 
+func (b *builder) SetEvents(format string) error {
+	if _, err := node.ParseEventsFormat(format); err != nil {
+		return err
+	}
+
+	b.plan.With(mess.WithEvents(node.NDJSONEvents(os.Stdout)))
+	return nil
+}
+
 func (b *builder) BuildFiles() error {
-	b.logger.Debug("Building files...")
-	defer b.logger.Debug("Build done!")
+	result, err := b.plan.Build(context.Background())
+	for _, warning := range result.Warnings {
+		b.logger.Warn("Skipped: %v", warning)
+	}
+
+	synced, syncTime := 0, time.Duration(0)
+	for _, e := range result.Events {
+		if e.Event == node.EventSync {
+			synced++
+			syncTime += e.Duration
+		}
+	}
+	if synced > 0 {
+		b.logger.Trace("Synced %d paths in %s", synced, syncTime)
+	}
 
-	return b.root.Root().BuildFiles()
+	return err
 }
@@ -11,7 +11,7 @@ type flagWrapper struct {
 }
 
 func simpleHelp(fs *flag.FlagSet) {
-	fmt.Fprintf(fs.Output(), "Usage: %s [-flags] <..|dir/|dir/file|file>[@<owner>|%%<perms>]...\n", fs.Name())
+	fmt.Fprintf(fs.Output(), "Usage: %s [-flags] <..|dir/|dir/file|file|dest<-src>[@<owner>|%%<perms>]...\n", fs.Name())
 }
 
 func NewCLI() *flagWrapper {
@@ -35,6 +35,11 @@ func (fw *flagWrapper) Parse() ([]string, error) {
 	return fw.Args(), nil
 }
 
+// Changed reports whether the flag was given on the command line.
+func (fw *flagWrapper) Changed(name string) bool {
+	return fw.fs.Changed(name)
+}
+
 func (fw *flagWrapper) Args() []string {
 	return fw.fs.Args()
 }
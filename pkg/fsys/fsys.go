@@ -0,0 +1,95 @@
+// Package fsys abstracts the filesystem calls mess builds trees with, so a
+// tree can be built on disk, below another directory or in memory.
+package fsys
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// FS is a writable filesystem addressed by absolute paths. Errors wrap the
+// io/fs ones, like fs.ErrNotExist, where they apply.
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+
+	Mkdir(name string, perm fs.FileMode) error
+	// Create creates name and opens it for writing. It fails with
+	// fs.ErrExist when anything is already there, a symlink included.
+	Create(name string, perm fs.FileMode) (File, error)
+	// Mknod creates a FIFO, socket or device node. mode holds the type bits
+	// (unix.S_IFIFO, ...) and the permission.
+	Mknod(name string, mode uint32, dev uint64) error
+	Symlink(target, name string) error
+	// Rename moves oldname to newname. It fails with fs.ErrExist when
+	// anything is already at newname.
+	Rename(oldname, newname string) error
+	// Remove removes the file or empty directory name.
+	Remove(name string) error
+
+	Chmod(name string, perm fs.FileMode) error
+	Chown(name string, uid, gid int) error
+	Lchown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Lchtimes(name string, atime, mtime time.Time) error
+	Setxattr(name, attr string, value []byte) error
+	Lsetxattr(name, attr string, value []byte) error
+
+	// Sync flushes the regular file or directory name to stable storage.
+	Sync(name string) error
+}
+
+// File is a regular file opened by FS.Create.
+type File interface {
+	io.WriteCloser
+	Truncate(size int64) error
+	Sync() error
+}
+
+// NeedsElevationStat reports whether what info describes can only be written
+// to as root. It goes by the mode, owner and group in info instead of asking
+// the kernel, so it doesn't take ACLs or read-only mounts into account.
+func NeedsElevationStat(info fs.FileInfo) bool {
+	if os.Geteuid() == 0 {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	perm := info.Mode().Perm()
+	switch {
+	case int(stat.Uid) == os.Geteuid():
+		return perm&0o200 == 0
+	case slices.Contains(groups(), int(stat.Gid)):
+		return perm&0o020 == 0
+	}
+	return perm&0o002 == 0
+}
+
+// groups returns the effective and supplementary groups of the caller.
+var groups = sync.OnceValue(func() []int {
+	list, _ := os.Getgroups()
+	return append(list, os.Getegid())
+})
+
+// UsersOf returns the accounts owners on f are resolved with: its own when
+// it has some, like a Rooted with staged accounts, the host's otherwise.
+func UsersOf(f FS) utils.Users {
+	if withUsers, ok := f.(interface{ Users() utils.Users }); ok {
+		if users := withUsers.Users(); users != nil {
+			return users
+		}
+	}
+	return utils.HostUsers{}
+}
@@ -0,0 +1,508 @@
+package fsys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mem is a filesystem kept in memory, starting out with an empty root
+// directory. It has no permission checks and follows symlinks like the OS.
+// It is also an fs.FS, with paths relative to its root, so built trees can
+// be read back with io/fs.
+type Mem struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+}
+
+var (
+	_ FS            = (*Mem)(nil)
+	_ fs.ReadDirFS  = (*Mem)(nil)
+	_ fs.ReadFileFS = (*Mem)(nil)
+)
+
+type memEntry struct {
+	name    string
+	mode    fs.FileMode
+	uid     int
+	gid     int
+	dev     uint64
+	modTime time.Time
+	data    []byte
+	target  string
+	xattrs  map[string][]byte
+}
+
+// NewMem returns an empty in-memory filesystem.
+func NewMem() *Mem {
+	return &Mem{entries: map[string]*memEntry{
+		"/": {name: "/", mode: fs.ModeDir | 0o755, modTime: time.Now()},
+	}}
+}
+
+func memClean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// lookup returns the entry at name, following symlinks in every element but
+// the last one unless follow is set. The returned path has no symlinks left.
+func (m *Mem) lookup(op, name string, follow bool) (string, *memEntry, error) {
+	pending := splitClean(name)
+	current := "/"
+
+	for hops := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+
+		if part == ".." {
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+		entry, ok := m.entries[next]
+		if !ok {
+			if len(pending) > 0 {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			return next, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		if entry.mode&fs.ModeSymlink != 0 && (len(pending) > 0 || follow) {
+			if hops++; hops > maxSymlinks {
+				return "", nil, &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+			}
+			if path.IsAbs(entry.target) {
+				current = "/"
+			}
+			pending = append(splitClean(entry.target), pending...)
+			continue
+		}
+
+		if len(pending) > 0 && !entry.mode.IsDir() {
+			return "", nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		current = next
+	}
+
+	return current, m.entries[current], nil
+}
+
+// add creates an entry at name, whose parent has to be an existing directory.
+func (m *Mem) add(op, name string, entry *memEntry) error {
+	parent, base := path.Split(memClean(name))
+	dir, parentEntry, err := m.lookup(op, parent, true)
+	if err != nil {
+		return err
+	}
+	if !parentEntry.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+
+	full := path.Join(dir, base)
+	if _, ok := m.entries[full]; ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+
+	entry.name = base
+	entry.uid, entry.gid = os.Getuid(), os.Getgid()
+	entry.modTime = time.Now()
+	m.entries[full] = entry
+	return nil
+}
+
+func (m *Mem) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, entry, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return entry.info(), nil
+}
+
+func (m *Mem) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, entry, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return entry.info(), nil
+}
+
+func (m *Mem) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, entry, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if entry.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return entry.target, nil
+}
+
+func (m *Mem) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.add("mkdir", name, &memEntry{mode: fs.ModeDir | perm.Perm()})
+}
+
+func (m *Mem) Create(name string, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	full, entry, err := m.lookup("open", name, false)
+	if entry != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if full == "" {
+		return nil, err
+	}
+
+	entry = &memEntry{mode: perm.Perm()}
+	if err := m.add("open", full, entry); err != nil {
+		return nil, err
+	}
+	return &memFile{mem: m, entry: entry}, nil
+}
+
+func (m *Mem) Mknod(name string, mode uint32, dev uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	perm := fs.FileMode(mode & 0o777)
+	switch mode & unix.S_IFMT {
+	case unix.S_IFIFO:
+		perm |= fs.ModeNamedPipe
+	case unix.S_IFSOCK:
+		perm |= fs.ModeSocket
+	case unix.S_IFCHR:
+		perm |= fs.ModeDevice | fs.ModeCharDevice
+	case unix.S_IFBLK:
+		perm |= fs.ModeDevice
+	default:
+		return &fs.PathError{Op: "mknod", Path: name, Err: syscall.EINVAL}
+	}
+
+	return m.add("mknod", name, &memEntry{mode: perm, dev: dev})
+}
+
+func (m *Mem) Symlink(target, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.add("symlink", name, &memEntry{mode: fs.ModeSymlink | 0o777, target: target})
+}
+
+func (m *Mem) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, entry, err := m.lookup("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	if from == "/" {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: syscall.EBUSY}
+	}
+	to, existing, err := m.lookup("rename", newname, false)
+	if existing != nil {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	if to == "" {
+		return err
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: syscall.EINVAL}
+	}
+
+	moved := map[string]*memEntry{to: entry}
+	for full, child := range m.entries {
+		if strings.HasPrefix(full, from+"/") {
+			moved[to+full[len(from):]] = child
+			delete(m.entries, full)
+		}
+	}
+	delete(m.entries, from)
+	maps.Copy(m.entries, moved)
+	entry.name = path.Base(to)
+	return nil
+}
+
+func (m *Mem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	full, entry, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if full == "/" {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if entry.mode.IsDir() && len(m.readDir(full)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(m.entries, full)
+	return nil
+}
+
+// change runs fn on the entry at name.
+func (m *Mem) change(op, name string, follow bool, fn func(*memEntry)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, entry, err := m.lookup(op, name, follow)
+	if err != nil {
+		return err
+	}
+	fn(entry)
+	return nil
+}
+
+func (m *Mem) Chmod(name string, perm fs.FileMode) error {
+	return m.change("chmod", name, true, func(e *memEntry) {
+		e.mode = e.mode&fs.ModeType | perm&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+	})
+}
+
+func (m *Mem) Chown(name string, uid, gid int) error {
+	return m.change("chown", name, true, func(e *memEntry) { e.uid, e.gid = uid, gid })
+}
+
+func (m *Mem) Lchown(name string, uid, gid int) error {
+	return m.change("lchown", name, false, func(e *memEntry) { e.uid, e.gid = uid, gid })
+}
+
+func (m *Mem) Chtimes(name string, atime, mtime time.Time) error {
+	return m.change("chtimes", name, true, func(e *memEntry) { e.modTime = mtime })
+}
+
+func (m *Mem) Lchtimes(name string, atime, mtime time.Time) error {
+	return m.change("lchtimes", name, false, func(e *memEntry) { e.modTime = mtime })
+}
+
+func (m *Mem) setxattr(op, name, attr string, value []byte, follow bool) error {
+	return m.change(op, name, follow, func(e *memEntry) {
+		if e.xattrs == nil {
+			e.xattrs = make(map[string][]byte)
+		}
+		e.xattrs[attr] = slices.Clone(value)
+	})
+}
+
+func (m *Mem) Setxattr(name, attr string, value []byte) error {
+	return m.setxattr("setxattr", name, attr, value, true)
+}
+
+func (m *Mem) Lsetxattr(name, attr string, value []byte) error {
+	return m.setxattr("lsetxattr", name, attr, value, false)
+}
+
+// Getxattr returns the extended attribute attr of name.
+func (m *Mem) Getxattr(name, attr string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, entry, err := m.lookup("getxattr", name, true)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := entry.xattrs[attr]
+	if !ok {
+		return nil, &fs.PathError{Op: "getxattr", Path: name, Err: unix.ENODATA}
+	}
+	return slices.Clone(value), nil
+}
+
+// Sync only checks that name exists, Mem has nothing to flush.
+func (m *Mem) Sync(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, _, err := m.lookup("sync", name, false)
+	return err
+}
+
+// fsName turns a path of io/fs into one of Mem.
+func fsName(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return "/" + name, nil
+}
+
+func (m *Mem) Open(name string) (fs.File, error) {
+	full, err := fsName("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resolved, entry, err := m.lookup("open", full, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.mode.IsDir() {
+		return &memDir{info: entry.info(), entries: m.readDir(resolved)}, nil
+	}
+	return &memReader{info: entry.info(), Reader: bytes.NewReader(slices.Clone(entry.data))}, nil
+}
+
+func (m *Mem) ReadFile(name string) ([]byte, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if r, ok := f.(*memReader); ok {
+		return io.ReadAll(r)
+	}
+	return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+}
+
+func (m *Mem) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := fsName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resolved, entry, err := m.lookup("readdir", full, true)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return m.readDir(resolved), nil
+}
+
+// readDir lists the entries right below dir, sorted by name.
+func (m *Mem) readDir(dir string) []fs.DirEntry {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	list := make([]fs.DirEntry, 0)
+	for full, entry := range m.entries {
+		if full == dir || !strings.HasPrefix(full, prefix) || strings.Contains(full[len(prefix):], "/") {
+			continue
+		}
+		list = append(list, fs.FileInfoToDirEntry(entry.info()))
+	}
+	slices.SortFunc(list, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return list
+}
+
+func (e *memEntry) info() fs.FileInfo {
+	return memInfo{
+		name:    e.name,
+		size:    int64(len(e.data)),
+		mode:    e.mode,
+		modTime: e.modTime,
+		sys:     e.stat(),
+	}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *syscall.Stat_t
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return i.sys }
+
+// memFile writes to a file of Mem.
+type memFile struct {
+	mem   *Mem
+	entry *memEntry
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	f.entry.data = append(f.entry.data, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if size <= int64(len(f.entry.data)) {
+		f.entry.data = f.entry.data[:size]
+	} else {
+		f.entry.data = append(f.entry.data, make([]byte, size-int64(len(f.entry.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+// memReader reads a file of Mem through io/fs.
+type memReader struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+// memDir lists a directory of Mem through io/fs.
+type memDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: syscall.EISDIR}
+}
+func (d *memDir) Close() error { return nil }
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		list := d.entries
+		d.entries = nil
+		return list, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	list := d.entries[:n]
+	d.entries = d.entries[n:]
+	return list, nil
+}
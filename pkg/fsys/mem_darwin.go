@@ -0,0 +1,13 @@
+package fsys
+
+import "syscall"
+
+// stat returns what Sys gives for the entry, like os.Stat does.
+func (e *memEntry) stat() *syscall.Stat_t {
+	return &syscall.Stat_t{
+		Uid:  uint32(e.uid),
+		Gid:  uint32(e.gid),
+		Rdev: int32(e.dev),
+		Size: int64(len(e.data)),
+	}
+}
@@ -0,0 +1,43 @@
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrUntrustedSymlink is returned when a path goes through a symlink in a
+// sticky world-writable directory, like /tmp, that neither the owner of the
+// directory nor the caller made.
+var ErrUntrustedSymlink = errors.New("untrusted symlink in a shared directory")
+
+// OS is the filesystem of the running system.
+//
+// The last element of a path is never followed: Chown, Chmod, Chtimes and
+// Setxattr act like their L variants, new files are created exclusively and
+// nothing is written through a symlink. On Linux changes are also made
+// relative to a descriptor of the parent directory, opened one element at a
+// time, so a path swapped for a symlink after being probed isn't followed.
+type OS struct {
+	// root, only set by Rooted on Linux, has the kernel resolve every path
+	// inside it.
+	root string
+}
+
+var _ FS = OS{}
+
+// unixMode converts perm to the mode bits of the *at calls.
+func unixMode(perm fs.FileMode) uint32 {
+	mode := uint32(perm.Perm())
+	if perm&fs.ModeSetuid != 0 {
+		mode |= unix.S_ISUID
+	}
+	if perm&fs.ModeSetgid != 0 {
+		mode |= unix.S_ISGID
+	}
+	if perm&fs.ModeSticky != 0 {
+		mode |= unix.S_ISVTX
+	}
+	return mode
+}
@@ -0,0 +1,345 @@
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func (o OS) Stat(name string) (fs.FileInfo, error) {
+	if o.root == "" {
+		return os.Stat(name)
+	}
+	return o.statInRoot("stat", name, 0)
+}
+
+func (o OS) Lstat(name string) (fs.FileInfo, error) {
+	if o.root == "" {
+		return os.Lstat(name)
+	}
+	return o.statInRoot("lstat", name, unix.O_NOFOLLOW)
+}
+
+// statInRoot stats name through a descriptor, O_PATH with O_NOFOLLOW opening
+// the symlink itself.
+func (o OS) statInRoot(op, name string, flags uint64) (fs.FileInfo, error) {
+	fd, err := o.openInRoot(name, unix.O_PATH|flags)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	file := os.NewFile(uintptr(fd), name)
+	defer file.Close()
+	return file.Stat()
+}
+
+func (o OS) Readlink(name string) (string, error) {
+	if o.root == "" {
+		return os.Readlink(name)
+	}
+
+	fd, err := o.openInRoot(name, unix.O_PATH|unix.O_NOFOLLOW)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(fd, "", buf)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return string(buf[:n]), nil
+}
+
+// inRoot returns o resolving every path inside root, or false when the
+// kernel has no openat2.
+func (o OS) inRoot(root string) (FS, bool) {
+	if !hasOpenat2() {
+		return nil, false
+	}
+	return OS{root: root}, true
+}
+
+var hasOpenat2 = sync.OnceValue(func() bool {
+	fd, err := unix.Openat2(unix.AT_FDCWD, "/", &unix.OpenHow{Flags: unix.O_PATH | unix.O_CLOEXEC})
+	if err != nil {
+		return false
+	}
+	unix.Close(fd)
+	return true
+})
+
+func (o OS) Mkdir(name string, perm fs.FileMode) error {
+	return o.at("mkdir", name, func(dirfd int, base string) error {
+		return unix.Mkdirat(dirfd, base, unixMode(perm))
+	})
+}
+
+func (o OS) Create(name string, perm fs.FileMode) (File, error) {
+	var file *os.File
+	err := o.at("open", name, func(dirfd int, base string) error {
+		fd, err := unix.Openat(dirfd, base, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, unixMode(perm))
+		if err != nil {
+			return err
+		}
+		file = os.NewFile(uintptr(fd), name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (o OS) Mknod(name string, mode uint32, dev uint64) error {
+	return o.at("mknod", name, func(dirfd int, base string) error {
+		return unix.Mknodat(dirfd, base, mode, int(dev))
+	})
+}
+
+func (o OS) Symlink(target, name string) error {
+	return o.at("symlink", name, func(dirfd int, base string) error {
+		return unix.Symlinkat(target, dirfd, base)
+	})
+}
+
+func (o OS) Rename(oldname, newname string) error {
+	return o.at("rename", oldname, func(olddirfd int, oldbase string) error {
+		clean := filepath.Clean(newname)
+		newdirfd, err := o.openDir(filepath.Dir(clean))
+		if err != nil {
+			return err
+		}
+		defer unix.Close(newdirfd)
+
+		return unix.Renameat2(olddirfd, oldbase, newdirfd, filepath.Base(clean), unix.RENAME_NOREPLACE)
+	})
+}
+
+func (o OS) Remove(name string) error {
+	return o.at("remove", name, func(dirfd int, base string) error {
+		err := unix.Unlinkat(dirfd, base, 0)
+		if err == unix.EISDIR {
+			err = unix.Unlinkat(dirfd, base, unix.AT_REMOVEDIR)
+		}
+		return err
+	})
+}
+
+func (o OS) Chmod(name string, perm fs.FileMode) error {
+	return o.at("chmod", name, func(dirfd int, base string) error {
+		err := unix.Fchmodat(dirfd, base, unixMode(perm), unix.AT_SYMLINK_NOFOLLOW)
+		if err != unix.EOPNOTSUPP {
+			return err
+		}
+
+		// Kernels without fchmodat2 can't do it directly: pin the file
+		// with O_PATH and change it through /proc instead.
+		fd, err := unix.Openat(dirfd, base, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+
+		var st unix.Stat_t
+		if err := unix.Fstat(fd, &st); err != nil {
+			return err
+		}
+		if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+			return unix.EOPNOTSUPP
+		}
+		return unix.Chmod(procPath(fd, ""), unixMode(perm))
+	})
+}
+
+func (o OS) Chown(name string, uid, gid int) error { return o.Lchown(name, uid, gid) }
+
+func (o OS) Lchown(name string, uid, gid int) error {
+	return o.at("lchown", name, func(dirfd int, base string) error {
+		return unix.Fchownat(dirfd, base, uid, gid, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
+
+func (o OS) Chtimes(name string, atime, mtime time.Time) error {
+	return o.Lchtimes(name, atime, mtime)
+}
+
+func (o OS) Lchtimes(name string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return o.at("lchtimes", name, func(dirfd int, base string) error {
+		return unix.UtimesNanoAt(dirfd, base, ts, unix.AT_SYMLINK_NOFOLLOW)
+	})
+}
+
+func (o OS) Setxattr(name, attr string, value []byte) error {
+	return o.Lsetxattr(name, attr, value)
+}
+
+func (o OS) Lsetxattr(name, attr string, value []byte) error {
+	return o.at("lsetxattr", name, func(dirfd int, base string) error {
+		return unix.Lsetxattr(procPath(dirfd, base), attr, value, 0)
+	})
+}
+
+func (o OS) Sync(name string) error {
+	return o.at("sync", name, func(dirfd int, base string) error {
+		fd, err := unix.Openat(dirfd, base, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+		return unix.Fsync(fd)
+	})
+}
+
+// at calls do with a descriptor of the parent directory of name and its last
+// element, wrapping the error like os does.
+func (o OS) at(op, name string, do func(dirfd int, base string) error) error {
+	clean := filepath.Clean(name)
+	dir, base := filepath.Dir(clean), filepath.Base(clean)
+	if base == "/" {
+		base = "."
+	}
+
+	dirfd, err := o.openDir(dir)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer unix.Close(dirfd)
+
+	if err := do(dirfd, base); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// openDir opens the directory name, inside the root when there is one.
+func (o OS) openDir(name string) (int, error) {
+	if o.root != "" {
+		return o.openInRoot(name, unix.O_PATH|unix.O_DIRECTORY)
+	}
+	return openDir(name)
+}
+
+// openInRoot opens name with openat2, which resolves every symlink and `..`
+// on the way inside the root.
+func (o OS) openInRoot(name string, flags uint64) (int, error) {
+	rootfd, err := unix.Open(o.root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(rootfd)
+
+	how := &unix.OpenHow{
+		Flags:   flags | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	for {
+		// EAGAIN means something was renamed inside the root meanwhile.
+		fd, err := unix.Openat2(rootfd, name, how)
+		if err != unix.EAGAIN {
+			return fd, err
+		}
+	}
+}
+
+// openDir opens the directory name one element at a time. Symlinks on the way
+// are followed unless ErrUntrustedSymlink applies, like the kernel does with
+// fs.protected_symlinks for the last element.
+func openDir(name string) (int, error) {
+	const flags = unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC
+
+	start := "/"
+	if !filepath.IsAbs(name) {
+		start = "."
+	}
+	fd, err := unix.Open(start, flags, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	pending := splitClean(name)
+	for hops := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+
+		next, err := unix.Openat(fd, part, flags|unix.O_NOFOLLOW, 0)
+		if err == nil {
+			unix.Close(fd)
+			fd = next
+			continue
+		}
+		if err != unix.ELOOP && err != unix.ENOTDIR {
+			unix.Close(fd)
+			return -1, err
+		}
+
+		target, lerr := trustedLink(fd, part)
+		if lerr != nil {
+			unix.Close(fd)
+			if lerr == unix.EINVAL {
+				// Not a symlink after all.
+				return -1, err
+			}
+			return -1, lerr
+		}
+		if hops++; hops > maxSymlinks {
+			unix.Close(fd)
+			return -1, unix.ELOOP
+		}
+
+		if filepath.IsAbs(target) {
+			unix.Close(fd)
+			if fd, err = unix.Open("/", flags, 0); err != nil {
+				return -1, err
+			}
+		}
+		pending = append(splitClean(target), pending...)
+	}
+
+	return fd, nil
+}
+
+// trustedLink returns the target of the symlink name in dirfd, or
+// ErrUntrustedSymlink when the directory is sticky and world-writable and the
+// link belongs to neither its owner nor the caller. It returns unix.EINVAL
+// when name isn't a symlink.
+func trustedLink(dirfd int, name string) (string, error) {
+	var dir, link unix.Stat_t
+	if err := unix.Fstat(dirfd, &dir); err != nil {
+		return "", err
+	}
+	if err := unix.Fstatat(dirfd, name, &link, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return "", err
+	}
+	if link.Mode&unix.S_IFMT != unix.S_IFLNK {
+		return "", unix.EINVAL
+	}
+
+	shared := dir.Mode&unix.S_ISVTX != 0 && dir.Mode&unix.S_IWOTH != 0
+	if shared && link.Uid != dir.Uid && int(link.Uid) != os.Geteuid() {
+		return "", ErrUntrustedSymlink
+	}
+
+	buf := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(dirfd, name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// procPath returns a path reaching name in the directory fd through
+// /proc/self/fd, for the calls that have no *at variant.
+func procPath(fd int, name string) string {
+	path := "/proc/self/fd/" + strconv.Itoa(fd)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
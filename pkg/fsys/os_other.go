@@ -0,0 +1,85 @@
+//go:build !linux
+
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Without O_PATH and /proc, changes go by path: the last element isn't
+// followed, but the directories on the way are looked up again by each call.
+
+func (OS) Stat(name string) (fs.FileInfo, error)  { return os.Stat(name) }
+func (OS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+func (OS) Readlink(name string) (string, error)   { return os.Readlink(name) }
+
+// inRoot always reports false: paths below a root are resolved by Rooted.
+func (OS) inRoot(string) (FS, bool) { return nil, false }
+
+func (OS) Mkdir(name string, perm fs.FileMode) error { return os.Mkdir(name, perm) }
+
+func (OS) Create(name string, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func (OS) Mknod(name string, mode uint32, dev uint64) error {
+	return pathError("mknod", name, unix.Mknod(name, mode, int(dev)))
+}
+
+func (OS) Symlink(target, name string) error { return os.Symlink(target, name) }
+
+// Rename links newname before removing oldname, since rename(2) can't be told
+// not to replace. Directories can't be renamed this way.
+func (OS) Rename(oldname, newname string) error {
+	if err := os.Link(oldname, newname); err != nil {
+		return err
+	}
+	return os.Remove(oldname)
+}
+
+func (OS) Remove(name string) error { return os.Remove(name) }
+
+func (OS) Chmod(name string, perm fs.FileMode) error {
+	return pathError("chmod", name, unix.Fchmodat(unix.AT_FDCWD, name, unixMode(perm), unix.AT_SYMLINK_NOFOLLOW))
+}
+
+func (OS) Chown(name string, uid, gid int) error  { return os.Lchown(name, uid, gid) }
+func (OS) Lchown(name string, uid, gid int) error { return os.Lchown(name, uid, gid) }
+
+func (o OS) Chtimes(name string, atime, mtime time.Time) error {
+	return o.Lchtimes(name, atime, mtime)
+}
+
+func (OS) Lchtimes(name string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return pathError("lchtimes", name, unix.UtimesNanoAt(unix.AT_FDCWD, name, ts, unix.AT_SYMLINK_NOFOLLOW))
+}
+
+func (o OS) Setxattr(name, attr string, value []byte) error {
+	return o.Lsetxattr(name, attr, value)
+}
+
+func (OS) Lsetxattr(name, attr string, value []byte) error {
+	return pathError("lsetxattr", name, unix.Lsetxattr(name, attr, value, 0))
+}
+
+func (OS) Sync(name string) error {
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return pathError("sync", name, err)
+	}
+	defer unix.Close(fd)
+	return pathError("sync", name, unix.Fsync(fd))
+}
+
+// pathError wraps err like os does, or returns nil when there's none.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
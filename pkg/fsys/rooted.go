@@ -0,0 +1,283 @@
+package fsys
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// maxSymlinks is how many symlinks a path may go through, like Linux.
+const maxSymlinks = 40
+
+// Rooted is FS with every path taken relative to Root, like after chroot(2).
+// Symlinks are resolved inside Root, so neither absolute targets nor `..`
+// lead out of it, and like OS the last element is never followed by changes.
+// On top of OS on Linux the kernel does the resolving with openat2, so
+// nothing swapped in meanwhile can lead out either; elsewhere paths are
+// resolved ahead of each call.
+type Rooted struct {
+	Root string
+	FS   FS
+
+	// Accounts resolves owners instead of the host, like the staged
+	// etc/passwd and etc/group from StagedUsers.
+	Accounts utils.Users
+}
+
+var _ FS = Rooted{}
+
+// NewRooted returns f seen from below root.
+func NewRooted(root string, f FS) Rooted {
+	return Rooted{Root: filepath.Clean(root), FS: f}
+}
+
+// StagedUsers returns the accounts of the etc/passwd and etc/group files
+// below the root.
+func (r Rooted) StagedUsers() *utils.FileUsers {
+	return utils.NewFileUsers(r.Path("/etc/passwd"), r.Path("/etc/group"))
+}
+
+func (r Rooted) Users() utils.Users {
+	return r.Accounts
+}
+
+// Path returns the path of name inside the root, without resolving symlinks.
+func (r Rooted) Path(name string) string {
+	return filepath.Join(r.Root, filepath.Clean("/"+name))
+}
+
+// on returns the FS name is looked up on and its path there: name itself when
+// the kernel resolves it inside the root, what resolve gives otherwise.
+func (r Rooted) on(name string, follow bool) (FS, string, error) {
+	if o, ok := r.FS.(OS); ok {
+		if f, ok := o.inRoot(r.Root); ok {
+			return f, name, nil
+		}
+	}
+
+	path, err := r.resolve(name, follow)
+	return r.FS, path, err
+}
+
+// resolve maps name to a path of r.FS. Symlinks on the way are followed
+// inside the root, the last element only when follow is set.
+func (r Rooted) resolve(name string, follow bool) (string, error) {
+	inner := func(path string) (fs.FileInfo, error) { return r.FS.Lstat(r.Path(path)) }
+	readlink := func(path string) (string, error) { return r.FS.Readlink(r.Path(path)) }
+
+	resolved, err := resolve(inner, readlink, name, follow)
+	if err != nil {
+		return "", err
+	}
+	return r.Path(resolved), nil
+}
+
+// Resolve returns name with the symlinks on f it goes through replaced by
+// their targets, the last element only when follow is set. Missing elements
+// are kept as they are.
+func Resolve(f FS, name string, follow bool) (string, error) {
+	return resolve(f.Lstat, f.Readlink, name, follow)
+}
+
+func resolve(lstat func(string) (fs.FileInfo, error), readlink func(string) (string, error), name string, follow bool) (string, error) {
+	pending := splitClean(name)
+	current := "/"
+
+	for hops := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+
+		if part == ".." {
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		if len(pending) == 0 && !follow {
+			current = next
+			break
+		}
+
+		info, err := lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			// Missing paths are left for the call to fail on.
+			current = next
+			continue
+		}
+
+		if hops++; hops > maxSymlinks {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+		}
+
+		target, err := readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			current = "/"
+		}
+		pending = append(splitClean(target), pending...)
+	}
+
+	return current, nil
+}
+
+// splitClean splits a path into its elements, keeping `..` for resolve.
+func splitClean(path string) []string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(path, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func (r Rooted) Stat(name string) (fs.FileInfo, error) {
+	f, path, err := r.on(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat(path)
+}
+
+func (r Rooted) Lstat(name string) (fs.FileInfo, error) {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.Lstat(path)
+}
+
+func (r Rooted) Readlink(name string) (string, error) {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return "", err
+	}
+	return f.Readlink(path)
+}
+
+func (r Rooted) Mkdir(name string, perm fs.FileMode) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Mkdir(path, perm)
+}
+
+func (r Rooted) Create(name string, perm fs.FileMode) (File, error) {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.Create(path, perm)
+}
+
+func (r Rooted) Mknod(name string, mode uint32, dev uint64) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Mknod(path, mode, dev)
+}
+
+func (r Rooted) Symlink(target, name string) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Symlink(target, path)
+}
+
+func (r Rooted) Rename(oldname, newname string) error {
+	f, oldpath, err := r.on(oldname, false)
+	if err != nil {
+		return err
+	}
+	_, newpath, err := r.on(newname, false)
+	if err != nil {
+		return err
+	}
+	return f.Rename(oldpath, newpath)
+}
+
+func (r Rooted) Remove(name string) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Remove(path)
+}
+
+func (r Rooted) Chmod(name string, perm fs.FileMode) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	// Like OS, symlinks are left alone rather than followed out of the
+	// root by r.FS.
+	if info, err := f.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return &fs.PathError{Op: "chmod", Path: name, Err: syscall.EOPNOTSUPP}
+	}
+	return f.Chmod(path, perm)
+}
+
+func (r Rooted) Chown(name string, uid, gid int) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lchown(path, uid, gid)
+}
+
+func (r Rooted) Lchown(name string, uid, gid int) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lchown(path, uid, gid)
+}
+
+func (r Rooted) Chtimes(name string, atime, mtime time.Time) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lchtimes(path, atime, mtime)
+}
+
+func (r Rooted) Lchtimes(name string, atime, mtime time.Time) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lchtimes(path, atime, mtime)
+}
+
+func (r Rooted) Setxattr(name, attr string, value []byte) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lsetxattr(path, attr, value)
+}
+
+func (r Rooted) Lsetxattr(name, attr string, value []byte) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Lsetxattr(path, attr, value)
+}
+
+func (r Rooted) Sync(name string) error {
+	f, path, err := r.on(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Sync(path)
+}
@@ -0,0 +1,83 @@
+package mess
+
+import (
+	"context"
+	"errors"
+
+	"github.com/devkcud/mess/pkg/node"
+)
+
+// Result is what Build did, or would do on a dry run, by path.
+type Result struct {
+	// Planned lists the paths that weren't on disk.
+	Planned []string
+	// Created lists the paths that were created.
+	Created []string
+	// Skipped lists the paths already on disk.
+	Skipped []string
+	// Conflicts lists the paths where something of another type is in the way.
+	Conflicts []string
+	// RolledBack lists the created paths removed again after a failure.
+	RolledBack []string
+	// Warnings are the problems that didn't stop the build, like extended
+	// attributes on a filesystem without support for them or owners that
+	// can't be changed unprivileged.
+	Warnings []error
+	// Events are all the steps in the order they happened.
+	Events []node.Event
+}
+
+func (r *Result) record(e node.Event) {
+	r.Events = append(r.Events, e)
+
+	switch e.Event {
+	case node.EventPlanned:
+		r.Planned = append(r.Planned, e.Path)
+	case node.EventMkdir, node.EventCreate:
+		r.Created = append(r.Created, e.Path)
+	case node.EventSkip:
+		if e.Error == "" {
+			r.Skipped = append(r.Skipped, e.Path)
+		}
+	case node.EventConflict:
+		r.Conflicts = append(r.Conflicts, e.Path)
+	case node.EventRollback:
+		if e.Error == "" {
+			r.RolledBack = append(r.RolledBack, e.Path)
+		}
+	}
+}
+
+// Build creates everything in the plan that isn't on disk yet. The result
+// is returned even when the build fails, up to the failing step.
+func (p *Plan) Build(ctx context.Context) (*Result, error) {
+	result := &Result{}
+
+	p.opts.logger.Debug("Building files...")
+	defer p.opts.logger.Debug("Build done!")
+
+	err := p.Tree().BuildFiles(ctx, node.BuildOptions{
+		Events: func(e node.Event) {
+			result.record(e)
+			if p.opts.events != nil {
+				p.opts.events(e)
+			}
+		},
+		DryRun:           p.opts.dryRun,
+		SkipConflicts:    p.opts.conflicts == ConflictSkip,
+		BestEffortOwners: p.opts.bestEffortOwners,
+		Sync:             p.opts.sync,
+		Jobs:             p.opts.jobs,
+		Rollback:         p.opts.rollback,
+	})
+	if errors.Is(err, node.ErrAttrUnsupported) || errors.Is(err, node.ErrOwnerNotApplied) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			result.Warnings = append(result.Warnings, joined.Unwrap()...)
+		} else {
+			result.Warnings = append(result.Warnings, err)
+		}
+		return result, nil
+	}
+
+	return result, err
+}
@@ -0,0 +1,149 @@
+package mess
+
+import (
+	"os"
+	"time"
+
+	"github.com/devkcud/mess/pkg/fsys"
+	"github.com/devkcud/mess/pkg/messlog"
+	"github.com/devkcud/mess/pkg/node"
+)
+
+// ConflictPolicy decides what Build does when something of another type is
+// already where a node goes, like a file where a directory is planned.
+type ConflictPolicy int
+
+const (
+	// ConflictFail stops the build at the first conflict.
+	ConflictFail ConflictPolicy = iota
+	// ConflictSkip leaves the conflicting path, and everything planned below
+	// it, alone and builds the rest.
+	ConflictSkip
+)
+
+type options struct {
+	base      string
+	dryRun    bool
+	owner     string
+	modTime   *time.Time
+	conflicts ConflictPolicy
+	logger    *messlog.Logger
+	events    node.EventFunc
+	fs        fsys.FS
+
+	bestEffortOwners bool
+	jail             bool
+	sync             bool
+	jobs             int
+	rollback         bool
+}
+
+// Option configures a Plan.
+type Option func(*options)
+
+func defaultOptions() options {
+	base, err := os.Getwd()
+	if err != nil {
+		base = "."
+	}
+
+	return options{
+		base:   base,
+		logger: messlog.NewLogger(messlog.LogLevelError),
+		fs:     fsys.OS{},
+	}
+}
+
+// WithBase sets the directory tokens are relative to. It defaults to the
+// working directory and only has an effect when passed to New.
+func WithBase(dir string) Option {
+	return func(o *options) { o.base = dir }
+}
+
+// WithDryRun makes Build report what it would do without touching the disk.
+func WithDryRun(dry bool) Option {
+	return func(o *options) { o.dryRun = dry }
+}
+
+// WithOwner sets the owner of new nodes that weren't given one with `@`.
+func WithOwner(owner string) Option {
+	return func(o *options) { o.owner = owner }
+}
+
+// WithModTime sets the timestamp of new nodes that weren't given one with `^`.
+func WithModTime(t time.Time) Option {
+	return func(o *options) { o.modTime = &t }
+}
+
+// WithConflictPolicy sets what Build does about conflicts.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(o *options) { o.conflicts = policy }
+}
+
+// WithLogger sets the logger the plan reports to. Only errors are logged by
+// default.
+func WithLogger(logger *messlog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithEvents sets a function receiving every step of Build as it happens.
+func WithEvents(events node.EventFunc) Option {
+	return func(o *options) { o.events = events }
+}
+
+// WithFS sets the filesystem the plan is probed and built on, like
+// fsys.NewMem() or fsys.NewRooted(dir, fsys.OS{}). It defaults to the disk
+// and only has an effect when passed to New.
+func WithFS(f fsys.FS) Option {
+	return func(o *options) { o.fs = f }
+}
+
+// WithRoot builds below dir as if it were `/`, like DESTDIR: absolute paths
+// and symlinks stay inside it. Owners that can't be changed without root
+// become warnings. With stagedUsers, owners are resolved with the
+// etc/passwd and etc/group below dir instead of the host's. Commands,
+// scripts and formats rendered from the tree keep the paths as seen from
+// inside dir. It only has an effect when passed to New.
+func WithRoot(dir string, stagedUsers bool) Option {
+	return func(o *options) {
+		rooted := fsys.NewRooted(dir, fsys.OS{})
+		if stagedUsers {
+			rooted.Accounts = rooted.StagedUsers()
+		}
+		o.fs = rooted
+		o.bestEffortOwners = os.Geteuid() != 0
+	}
+}
+
+// WithBestEffortOwners makes owners that can't be changed warnings instead of
+// errors.
+func WithBestEffortOwners(bestEffort bool) Option {
+	return func(o *options) { o.bestEffortOwners = bestEffort }
+}
+
+// WithJail rejects tokens and paths that would put nodes outside the base
+// directory, whether through `..`, absolute paths, `~` or symlinks already on
+// disk. It only has an effect when passed to New.
+func WithJail(jail bool) Option {
+	return func(o *options) { o.jail = jail }
+}
+
+// WithSync makes Build write files with content through a temporary file
+// renamed in place and flush everything it created to stable storage, so the
+// tree survives a crash.
+func WithSync(sync bool) Option {
+	return func(o *options) { o.sync = sync }
+}
+
+// WithRollback makes a failed Build remove what it created before failing,
+// leaving the disk as it was.
+func WithRollback(rollback bool) Option {
+	return func(o *options) { o.rollback = rollback }
+}
+
+// WithJobs sets how many steps Build runs at once. Directories are still
+// created parents first, and events and results keep the order of a build
+// with a single job.
+func WithJobs(jobs int) Option {
+	return func(o *options) { o.jobs = jobs }
+}
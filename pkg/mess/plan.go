@@ -0,0 +1,174 @@
+// Package mess plans and builds trees of files and directories from the same
+// tokens the mess command takes.
+//
+//	plan := mess.New(mess.WithBase(dir))
+//	for _, token := range []string{"cmd/", "main.go", "..", "go.mod%600"} {
+//		if err := plan.AddToken(token); err != nil {
+//			return err
+//		}
+//	}
+//	result, err := plan.Build(ctx)
+package mess
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/node"
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// Plan is a tree of nodes waiting to be built or rendered, with a cursor
+// that directory tokens move into and `..` moves out of.
+type Plan struct {
+	opts options
+
+	root *node.Node
+	base *node.Node
+}
+
+// New returns an empty plan rooted at the base directory.
+func New(opts ...Option) *Plan {
+	p := &Plan{opts: defaultOptions()}
+	p.With(opts...)
+
+	p.root = node.NewWithFS(p.opts.base, p.opts.fs)
+	p.base = p.root
+	if p.opts.jail {
+		p.base.Jail()
+	}
+	return p
+}
+
+// With applies more options to the plan and returns it.
+func (p *Plan) With(opts ...Option) *Plan {
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+	return p
+}
+
+// Base returns the node of the base directory, with the defaults of the
+// options applied like Tree.
+func (p *Plan) Base() *node.Node {
+	p.Tree()
+	return p.base
+}
+
+// Tree returns the root of the whole tree, probed on the filesystem and with
+// the owner and timestamp defaults of the options applied.
+func (p *Plan) Tree() *node.Node {
+	tree := p.root.Root()
+	tree.Probe(p.opts.jobs)
+
+	if p.opts.owner != "" {
+		tree.SetDefaultOwner(p.opts.owner)
+	}
+	if p.opts.modTime != nil {
+		tree.SetDefaultModTime(*p.opts.modTime)
+	}
+
+	return tree
+}
+
+// AddToken adds a token the way the mess command reads its arguments:
+// `dir/` adds a directory and moves into it, `..` moves back out, `dir/file`
+// and `file` add a file and `dest<-src` a copy.
+func (p *Plan) AddToken(token string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.opts.logger.Trace("Panic detected: %v\n%s", r, debug.Stack())
+			err = recovered(token, r)
+		}
+	}()
+
+	switch {
+	case strings.Contains(token, node.CopyOperator):
+		p.opts.logger.Debug("Rule found: dest<-src")
+		dest, src, _ := strings.Cut(token, node.CopyOperator)
+		p.addCopy(dest, src)
+		p.opts.logger.Trace("Stack tree added a copy of %s: %s", src, token)
+
+	case token == "..":
+		p.opts.logger.Debug("Rule found: ..")
+		if p.opts.jail && p.root == p.base {
+			return fmt.Errorf("token %q: %w: %s", token, node.ErrOutsideBase, p.base.Up().BuildPathBackwards())
+		}
+		p.root = p.root.Up()
+		p.opts.logger.Trace("Stack tree moved up one parent: %s", token)
+
+	case strings.HasSuffix(token, utils.OSPathSeparator):
+		p.opts.logger.Debug("Rule found: dir/")
+		p.addDirectory(token)
+		p.opts.logger.Trace("Stack tree added one directory: %s", token)
+
+	case strings.Contains(token, utils.OSPathSeparator):
+		p.opts.logger.Debug("Rule found: dir/file")
+		dir, file := filepath.Split(token)
+
+		cur := p.root
+		p.addDirectory(dir)
+		p.addFile(file)
+		p.root = cur
+
+		p.opts.logger.Trace("Stack tree added one directory and one file: %s", token)
+		p.opts.logger.Trace("`currentTree` remains intact")
+
+	default:
+		p.opts.logger.Debug("Rule found: file")
+		p.addFile(token)
+		p.opts.logger.Trace("Stack tree added one file: %s", token)
+	}
+
+	return
+}
+
+// AddPath adds a path relative to the base directory, or an absolute one,
+// without moving the cursor. Paths ending in `/` are directories. Markers
+// like `%600` work in every element.
+func (p *Plan) AddPath(path string) (n *node.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(path, r)
+		}
+	}()
+
+	if strings.HasSuffix(path, utils.OSPathSeparator) {
+		n = p.base.AddDirectory(path)
+	} else {
+		n = p.base.AddFile(path)
+	}
+	p.opts.logger.Info("Added path %s", path)
+
+	return n, nil
+}
+
+func (p *Plan) addDirectory(path string) {
+	p.opts.logger.Info("Added directory %s", path)
+	p.root = p.root.AddDirectory(path)
+}
+
+func (p *Plan) addFile(path string) {
+	p.opts.logger.Info("Added file %s", path)
+	p.root.AddFile(path)
+}
+
+func (p *Plan) addCopy(dest, src string) {
+	p.opts.logger.Info("Added copy of %s as %s", src, dest)
+	copied := p.root.AddCopy(dest, src)
+	if strings.HasSuffix(dest, utils.OSPathSeparator) {
+		p.root = copied
+	}
+}
+
+// recovered turns a panic of the node package while adding token into an
+// error. Jail violations name the token.
+func recovered(token string, r any) error {
+	if err, ok := r.(error); ok && errors.Is(err, node.ErrOutsideBase) {
+		return fmt.Errorf("token %q: %w", token, err)
+	}
+	return fmt.Errorf("panic occurred: %v", r)
+}
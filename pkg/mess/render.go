@@ -0,0 +1,49 @@
+package mess
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/devkcud/mess/pkg/node"
+)
+
+const (
+	RenderTree     = "tree"
+	RenderLong     = "long"
+	RenderJSON     = "json"
+	RenderJSONFlat = "json-flat"
+	RenderScript   = "script"
+)
+
+// Render writes the plan without building it. The format is one of the
+// Render constants, an echo dialect like `sh` or `make`, or one of
+// node.Formats like `ansible` or `dot`.
+func (p *Plan) Render(w io.Writer, format string) error {
+	tree := p.Tree()
+
+	switch format {
+	case RenderTree:
+		tree.WriteNodeTree(w)
+		return nil
+	case RenderLong:
+		tree.WriteLongTree(w, false)
+		return nil
+	case RenderJSON, RenderJSONFlat:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(tree.Document(format == RenderJSONFlat))
+	case RenderScript:
+		return tree.WriteScript(w)
+	}
+
+	if dialect, err := node.ParseDialect(format); err == nil {
+		return node.WriteCommands(w, tree.Operations(), dialect)
+	}
+
+	if f, err := node.ParseFormat(format); err == nil {
+		return tree.Render(w, f)
+	}
+
+	return fmt.Errorf("%w: %q", node.ErrUnknownFormat, format)
+}
@@ -0,0 +1,447 @@
+package node
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveTar    ArchiveFormat = "tar"
+	ArchiveTarGz  ArchiveFormat = "tar.gz"
+	ArchiveZip    ArchiveFormat = "zip"
+	ArchiveCpio   ArchiveFormat = "cpio"
+	ArchiveCpioGz ArchiveFormat = "cpio.gz"
+)
+
+var (
+	ErrUnknownArchive = errors.New("unknown archive format, expected .tar, .tar.gz, .tgz, .zip, .cpio or .cpio.gz")
+	ErrOutsideBase    = errors.New("path is outside the base directory")
+)
+
+// ArchiveFormatFromName picks the archive format from the extension of name.
+func ArchiveFormatFromName(name string) (ArchiveFormat, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(name, ".tar"):
+		return ArchiveTar, nil
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip, nil
+	case strings.HasSuffix(name, ".cpio.gz"):
+		return ArchiveCpioGz, nil
+	case strings.HasSuffix(name, ".cpio"):
+		return ArchiveCpio, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownArchive, name)
+}
+
+// archiveEntry is a node as it is stored in an archive, named relative to the
+// directory the archive is built from.
+type archiveEntry struct {
+	node *Node
+	name string
+
+	uid, gid     int
+	uname, gname string
+	mtime        time.Time
+}
+
+// content opens the data of a regular file entry.
+func (e archiveEntry) content() (io.ReadCloser, int64, error) {
+	node := e.node
+	if node.Source != "" {
+		f, err := os.Open(node.Source)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+
+	var r io.Reader = zeroReader{}
+	if node.Fill == FillRandom {
+		r = rand.Reader
+	}
+	return io.NopCloser(io.LimitReader(r, node.Size)), node.Size, nil
+}
+
+// archiveEntries lists the descendants of n, parents first. Nodes without a
+// timestamp get now.
+func (n *Node) archiveEntries(now time.Time) ([]archiveEntry, error) {
+	base := n.BuildPathBackwards()
+	entries := make([]archiveEntry, 0)
+
+	var walk func(node *Node) error
+	walk = func(node *Node) error {
+		for _, child := range node.Children {
+			name, err := filepath.Rel(base, child.BuildPathBackwards())
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+
+			entry := archiveEntry{node: child, name: name, uname: child.Owner, mtime: now}
+			if child.ModTime != nil {
+				entry.mtime = *child.ModTime
+			}
+			if uid, gid, err := utils.LookupOwner(child.users(), child.Owner); err == nil {
+				entry.uid, entry.gid = uid, gid
+			}
+			if group, gid, ok := child.groupOf(); ok {
+				entry.gname, entry.gid = group, gid
+			}
+
+			entries = append(entries, entry)
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(n); err != nil {
+		return nil, err
+	}
+
+	// Only descendants are stored, so anything above the base directory that
+	// the plan reaches with `..` or an absolute path can't be represented.
+	var outside func(node *Node) error
+	outside = func(node *Node) error {
+		if node == n {
+			return nil
+		}
+
+		for _, child := range node.Children {
+			if child != n && !child.isAncestorOf(n) {
+				return fmt.Errorf("%w: %s", ErrOutsideBase, child.BuildPathBackwards())
+			}
+			if err := outside(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := outside(n.Root()); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (n *Node) isAncestorOf(other *Node) bool {
+	for current := other.Parent; current != nil; current = current.Parent {
+		if current == n {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteArchive writes the descendants of n into an archive instead of onto
+// the disk. Nodes without their own timestamp are stamped with now.
+func (n *Node) WriteArchive(w io.Writer, format ArchiveFormat, now time.Time) error {
+	entries, err := n.archiveEntries(now)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case ArchiveTar:
+		return writeTar(w, entries)
+	case ArchiveZip:
+		return writeZip(w, entries)
+	case ArchiveCpio:
+		return writeCpio(w, entries)
+	case ArchiveTarGz, ArchiveCpioGz:
+		gz := gzip.NewWriter(w)
+		if format == ArchiveTarGz {
+			err = writeTar(gz, entries)
+		} else {
+			err = writeCpio(gz, entries)
+		}
+		if err != nil {
+			gz.Close()
+			return err
+		}
+		return gz.Close()
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnknownArchive, format)
+}
+
+func writeTar(w io.Writer, entries []archiveEntry) error {
+	tw := tar.NewWriter(w)
+
+	for _, entry := range entries {
+		node := entry.node
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    int64(octalBits(node.Permission)),
+			Uid:     entry.uid,
+			Gid:     entry.gid,
+			Uname:   entry.uname,
+			Gname:   entry.gname,
+			ModTime: entry.mtime,
+			Format:  tar.FormatPAX,
+		}
+
+		for _, xattr := range node.xattrList() {
+			name, value, _ := strings.Cut(xattr, "=")
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			header.PAXRecords["SCHILY.xattr."+name] = value
+		}
+
+		var access, defaults []string
+		for _, acl := range node.ACL {
+			if rest, ok := strings.CutPrefix(acl, "default:"); ok {
+				defaults = append(defaults, rest)
+			} else {
+				access = append(access, acl)
+			}
+		}
+		if len(access) > 0 || len(defaults) > 0 {
+			if header.PAXRecords == nil {
+				header.PAXRecords = make(map[string]string)
+			}
+			if len(access) > 0 {
+				header.PAXRecords["SCHILY.acl.access"] = strings.Join(access, ",")
+			}
+			if len(defaults) > 0 {
+				header.PAXRecords["SCHILY.acl.default"] = strings.Join(defaults, ",")
+			}
+		}
+
+		var content io.ReadCloser
+		switch node.Type {
+		case TypeDirectory:
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case TypeSymlink:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = node.Target
+		case TypeFIFO:
+			header.Typeflag = tar.TypeFifo
+		case TypeCharDevice, TypeBlockDevice:
+			header.Typeflag = tar.TypeChar
+			if node.Type == TypeBlockDevice {
+				header.Typeflag = tar.TypeBlock
+			}
+			header.Devmajor = int64(node.Device.Major)
+			header.Devminor = int64(node.Device.Minor)
+		case TypeSocket:
+			// tar has no socket entries, the placeholder is recreated on build.
+			continue
+		default:
+			header.Typeflag = tar.TypeReg
+			r, size, err := entry.content()
+			if err != nil {
+				return err
+			}
+			content = r
+			header.Size = size
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			if content != nil {
+				content.Close()
+			}
+			return fmt.Errorf("%w: %s", err, entry.name)
+		}
+
+		if content != nil {
+			_, err := io.Copy(tw, content)
+			content.Close()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, entry.name)
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		node := entry.node
+		header := &zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Deflate,
+			Modified: entry.mtime,
+		}
+
+		mode := node.Permission & modeBits
+		switch node.Type {
+		case TypeDirectory:
+			mode |= os.ModeDir
+			header.Name += "/"
+			header.Method = zip.Store
+		case TypeSymlink:
+			mode |= os.ModeSymlink
+		case TypeFIFO:
+			mode |= os.ModeNamedPipe
+		case TypeSocket:
+			mode |= os.ModeSocket
+		case TypeCharDevice:
+			mode |= os.ModeDevice | os.ModeCharDevice
+		case TypeBlockDevice:
+			mode |= os.ModeDevice
+		}
+		header.SetMode(mode)
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, entry.name)
+		}
+
+		switch node.Type {
+		case TypeSymlink:
+			if _, err := io.WriteString(fw, node.Target); err != nil {
+				return err
+			}
+		case TypeFile:
+			content, _, err := entry.content()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, content)
+			content.Close()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, entry.name)
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+const (
+	cpioRegular   = 0o100000
+	cpioDirectory = 0o040000
+	cpioSymlink   = 0o120000
+	cpioFIFO      = 0o010000
+	cpioSocket    = 0o140000
+	cpioChar      = 0o020000
+	cpioBlock     = 0o060000
+)
+
+// writeCpio writes the SVR4 "newc" format used by initramfs and rpm.
+func writeCpio(w io.Writer, entries []archiveEntry) error {
+	var written int64
+	write := func(p []byte) error {
+		n, err := w.Write(p)
+		written += int64(n)
+		return err
+	}
+	pad := func() error {
+		if rest := written % 4; rest != 0 {
+			return write(make([]byte, 4-rest))
+		}
+		return nil
+	}
+
+	header := func(ino int, mode uint32, entry *archiveEntry, size int64, name string) error {
+		var uid, gid, mtime int64
+		var nlink uint32 = 1
+		var rdevMajor, rdevMinor uint32
+		if entry != nil {
+			uid, gid, mtime = int64(entry.uid), int64(entry.gid), entry.mtime.Unix()
+			if entry.node.Type == TypeDirectory {
+				nlink = 2
+			}
+			if entry.node.Device != nil {
+				rdevMajor, rdevMinor = entry.node.Device.Major, entry.node.Device.Minor
+			}
+		}
+
+		fields := fmt.Sprintf("070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+			ino, mode, uid, gid, nlink, mtime, size, 0, 0, rdevMajor, rdevMinor, len(name)+1, 0)
+		if err := write([]byte(fields + name + "\x00")); err != nil {
+			return err
+		}
+		return pad()
+	}
+
+	for i, entry := range entries {
+		node := entry.node
+		mode := octalBits(node.Permission)
+
+		var content io.ReadCloser
+		var size int64
+		switch node.Type {
+		case TypeDirectory:
+			mode |= cpioDirectory
+		case TypeSymlink:
+			mode |= cpioSymlink
+			content = io.NopCloser(strings.NewReader(node.Target))
+			size = int64(len(node.Target))
+		case TypeFIFO:
+			mode |= cpioFIFO
+		case TypeSocket:
+			mode |= cpioSocket
+		case TypeCharDevice:
+			mode |= cpioChar
+		case TypeBlockDevice:
+			mode |= cpioBlock
+		default:
+			mode |= cpioRegular
+			r, s, err := entry.content()
+			if err != nil {
+				return err
+			}
+			content, size = r, s
+		}
+
+		if err := header(i+1, mode, &entry, size, entry.name); err != nil {
+			if content != nil {
+				content.Close()
+			}
+			return err
+		}
+
+		if content != nil {
+			n, err := io.Copy(writerFunc(write), content)
+			content.Close()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, entry.name)
+			}
+			if n != size {
+				return fmt.Errorf("%w: %s", io.ErrUnexpectedEOF, entry.name)
+			}
+			if err := pad(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return header(0, 0, nil, 0, "TRAILER!!!")
+}
+
+type writerFunc func(p []byte) error
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,357 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/fsys"
+	"github.com/devkcud/mess/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrBadAttribute     = errors.New("invalid attribute, expected user.<name>=<value> or an acl entry like [d:]u:<user>:rwx")
+	ErrAttrUnsupported  = errors.New("filesystem does not support extended attributes or acls")
+	ErrDefaultACLNonDir = errors.New("default acl entries only apply to directories")
+)
+
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+
+	aclVersion = 2
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclUndefinedID = 0xffffffff
+)
+
+var aclTags = map[string]string{
+	"u": "user", "user": "user",
+	"g": "group", "group": "group",
+	"m": "mask", "mask": "mask",
+	"o": "other", "other": "other",
+}
+
+// parseAttributes splits a comma separated attribute list into extended
+// attributes (`name=value`) and acl entries (`[d:]u:bob:rwx`). A backslash
+// keeps the next character, a comma included, as it is.
+func parseAttributes(s string) (map[string]string, []string, error) {
+	return parseAttributeItems(attributeItems(s))
+}
+
+// attributeItems splits s at the commas without a backslash before them and
+// drops the backslashes. There is always at least one item.
+func attributeItems(s string) []string {
+	items := make([]string, 0)
+	var item strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			item.WriteByte(s[i])
+		case s[i] == ',':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(s[i])
+		}
+	}
+	return append(items, item.String())
+}
+
+func parseAttributeItems(items []string) (map[string]string, []string, error) {
+	var (
+		xattrs map[string]string
+		acl    []string
+	)
+
+	for _, item := range items {
+		if name, value, ok := strings.Cut(item, "="); ok {
+			if !validXattrName(name) {
+				return nil, nil, fmt.Errorf("%w: %q", ErrBadAttribute, item)
+			}
+			if xattrs == nil {
+				xattrs = make(map[string]string)
+			}
+			xattrs[name] = value
+			continue
+		}
+
+		entry, err := normalizeACLEntry(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		acl = append(acl, entry)
+	}
+
+	return xattrs, acl, nil
+}
+
+// validXattrName reports whether name is in one of the namespaces of Linux
+// extended attributes, with something after the namespace.
+func validXattrName(name string) bool {
+	for _, namespace := range []string{"user.", "trusted.", "security.", "system."} {
+		if len(name) > len(namespace) && strings.HasPrefix(name, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeACLEntry rewrites an acl entry in the long form setfacl prints,
+// e.g. `d:g:dev:rx` becomes `default:group:dev:r-x`.
+func normalizeACLEntry(entry string) (string, error) {
+	parts := strings.Split(entry, ":")
+
+	prefix := ""
+	if len(parts) > 0 && (parts[0] == "d" || parts[0] == "default") {
+		prefix = "default:"
+		parts = parts[1:]
+	}
+
+	if len(parts) == 2 {
+		parts = []string{parts[0], "", parts[1]}
+	}
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrBadAttribute, entry)
+	}
+
+	tag, ok := aclTags[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrBadAttribute, entry)
+	}
+
+	if (tag == "mask" || tag == "other") && parts[1] != "" {
+		return "", fmt.Errorf("%w: %q", ErrBadAttribute, entry)
+	}
+
+	perms, err := parseACLPerms(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrBadAttribute, entry)
+	}
+
+	return fmt.Sprintf("%s%s:%s:%s", prefix, tag, parts[1], formatACLPerms(perms)), nil
+}
+
+func parseACLPerms(s string) (uint16, error) {
+	if len(s) == 1 && s[0] >= '0' && s[0] <= '7' {
+		return uint16(s[0] - '0'), nil
+	}
+
+	var perms uint16
+	for _, c := range s {
+		switch c {
+		case 'r':
+			perms |= 4
+		case 'w':
+			perms |= 2
+		case 'x':
+			perms |= 1
+		case '-':
+		default:
+			return 0, ErrBadAttribute
+		}
+	}
+	return perms, nil
+}
+
+func formatACLPerms(perms uint16) string {
+	b := []byte("---")
+	if perms&4 != 0 {
+		b[0] = 'r'
+	}
+	if perms&2 != 0 {
+		b[1] = 'w'
+	}
+	if perms&1 != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+type aclEntry struct {
+	tag   uint16
+	perms uint16
+	id    uint32
+}
+
+// encodeACL builds the binary value of a posix acl xattr. Missing owner, group
+// and other entries are taken from mode, and a mask is computed when named
+// entries are present without one.
+func encodeACL(users utils.Users, entries []string, mode os.FileMode) ([]byte, error) {
+	acl := make([]aclEntry, 0, len(entries)+4)
+	seen := make(map[uint16]bool)
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		tag, qualifier, perms := parts[0], parts[1], parts[2]
+		p, _ := parseACLPerms(perms)
+
+		e := aclEntry{perms: p, id: aclUndefinedID}
+		switch {
+		case tag == "user" && qualifier == "":
+			e.tag = aclUserObj
+		case tag == "user":
+			e.tag = aclUser
+			id, err := lookupACLID(users, qualifier, false)
+			if err != nil {
+				return nil, err
+			}
+			e.id = id
+		case tag == "group" && qualifier == "":
+			e.tag = aclGroupObj
+		case tag == "group":
+			e.tag = aclGroup
+			id, err := lookupACLID(users, qualifier, true)
+			if err != nil {
+				return nil, err
+			}
+			e.id = id
+		case tag == "mask":
+			e.tag = aclMask
+		case tag == "other":
+			e.tag = aclOther
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrBadAttribute, entry)
+		}
+
+		seen[e.tag] = true
+		acl = append(acl, e)
+	}
+
+	perm := uint16(mode.Perm())
+	if !seen[aclUserObj] {
+		acl = append(acl, aclEntry{tag: aclUserObj, perms: perm >> 6 & 7, id: aclUndefinedID})
+	}
+	if !seen[aclGroupObj] {
+		acl = append(acl, aclEntry{tag: aclGroupObj, perms: perm >> 3 & 7, id: aclUndefinedID})
+	}
+	if !seen[aclOther] {
+		acl = append(acl, aclEntry{tag: aclOther, perms: perm & 7, id: aclUndefinedID})
+	}
+	if !seen[aclMask] && (seen[aclUser] || seen[aclGroup]) {
+		var mask uint16
+		for _, e := range acl {
+			if e.tag == aclUser || e.tag == aclGroup || e.tag == aclGroupObj {
+				mask |= e.perms
+			}
+		}
+		acl = append(acl, aclEntry{tag: aclMask, perms: mask, id: aclUndefinedID})
+	}
+
+	slices.SortFunc(acl, func(a, b aclEntry) int {
+		if a.tag != b.tag {
+			return int(a.tag) - int(b.tag)
+		}
+		return int(int64(a.id) - int64(b.id))
+	})
+
+	buf := binary.LittleEndian.AppendUint32(nil, aclVersion)
+	for _, e := range acl {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.perms)
+		buf = binary.LittleEndian.AppendUint32(buf, e.id)
+	}
+	return buf, nil
+}
+
+func lookupACLID(users utils.Users, name string, group bool) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+
+	var (
+		id  string
+		err error
+	)
+	if group {
+		var g *user.Group
+		if g, err = users.LookupGroup(name); err == nil {
+			id = g.Gid
+		}
+	} else {
+		var u *user.User
+		if u, err = users.LookupUser(name); err == nil {
+			id = u.Uid
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	parsed, err := strconv.ParseUint(id, 10, 32)
+	return uint32(parsed), err
+}
+
+// setAttributes applies the extended attributes and acl of sn. Filesystems
+// without xattr or acl support return an error wrapping ErrAttrUnsupported.
+func setAttributes(f fsys.FS, users utils.Users, sn simpleNode) error {
+	set := f.Setxattr
+	if sn.ntype == TypeSymlink {
+		set = f.Lsetxattr
+	}
+
+	names := make([]string, 0, len(sn.xattrs))
+	for name := range sn.xattrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := set(sn.fpath, name, []byte(sn.xattrs[name])); err != nil {
+			return attributeError(err, name)
+		}
+	}
+
+	var access, defaults []string
+	for _, entry := range sn.acl {
+		if rest, ok := strings.CutPrefix(entry, "default:"); ok {
+			defaults = append(defaults, rest)
+		} else {
+			access = append(access, entry)
+		}
+	}
+
+	if len(defaults) > 0 && sn.ntype != TypeDirectory {
+		return ErrDefaultACLNonDir
+	}
+
+	for _, acl := range []struct {
+		xattr   string
+		entries []string
+	}{{aclAccessXattr, access}, {aclDefaultXattr, defaults}} {
+		if len(acl.entries) == 0 {
+			continue
+		}
+
+		value, err := encodeACL(users, acl.entries, sn.perms)
+		if err != nil {
+			return err
+		}
+
+		if err := set(sn.fpath, acl.xattr, value); err != nil {
+			return attributeError(err, acl.xattr)
+		}
+	}
+
+	return nil
+}
+
+func attributeError(err error, name string) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w: %s", ErrAttrUnsupported, name)
+	}
+	return fmt.Errorf("%w: %s", err, name)
+}
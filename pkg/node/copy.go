@@ -0,0 +1,193 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+const CopyOperator = "<-"
+
+// ErrTypeMismatch is a copy onto a node already planned as another type,
+// other than a directory.
+var ErrTypeMismatch = errors.New("path is already planned as another type")
+
+// AddCopy plans a copy of the existing file, symlink, special file or
+// directory tree at src as dest and returns the node dest names. A dest
+// ending in a separator is a directory: a directory source has its contents
+// copied into it and any other source is copied into it under its own name.
+//
+// Copied nodes are planned with the mode, setuid, setgid and sticky bits
+// included, and the modification time of their source; building sets the
+// mode again once created, past the umask. The owner is only kept when
+// running as root, the same way `cp -a` behaves. `@`/`%` suffixes on the
+// last segment of dest override the top node, and an owner override applies
+// to the whole copied subtree.
+func (n *Node) AddCopy(dest, src string) *Node {
+	src, err := filepath.Abs(ExpandUserHome(src))
+	if err != nil {
+		panic(err)
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		panic(err)
+	}
+
+	override := &NodeInformation{}
+	if parts := utils.SplitPath(strings.TrimSuffix(dest, utils.OSPathSeparator)); len(parts) > 0 {
+		last := parts[len(parts)-1]
+		if last != "" && last != "." && last != ".." && last != utils.OSPathSeparator {
+			if override, err = ParsePathPart(last); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	if dest == "" || strings.HasSuffix(dest, utils.OSPathSeparator) {
+		directory := n.AddDirectory(dest)
+		if directory == nil {
+			panic(fmt.Errorf("%w: %s", ErrNotDirectory, dest))
+		}
+
+		if !info.IsDir() {
+			directory.copyEntry(filepath.Base(src), src, info, override.Owner)
+			return directory
+		}
+
+		if dest != "" {
+			directory.applySource(src, info, override)
+		}
+		directory.copyChildren(src, override.Owner)
+		return directory
+	}
+
+	dir, _ := filepath.Split(dest)
+	parent := n
+	if dir != "" {
+		parent = n.AddDirectory(dir)
+		if parent == nil {
+			panic(fmt.Errorf("%w: %s", ErrNotDirectory, dir))
+		}
+	}
+
+	if override.Name == "" {
+		panic(ErrEmptyName)
+	}
+
+	copied := parent.copyEntry(override.Name, src, info, override.Owner)
+	copied.applySource(src, info, override)
+	return copied
+}
+
+// copyEntry adds (or reuses) the child called name as a copy of src.
+func (n *Node) copyEntry(name, src string, info os.FileInfo, owner string) *Node {
+	nodeType := TypeFile
+	switch {
+	case info.IsDir():
+		nodeType = TypeDirectory
+	case info.Mode()&os.ModeSymlink != 0:
+		nodeType = TypeSymlink
+	case info.Mode()&os.ModeNamedPipe != 0:
+		nodeType = TypeFIFO
+	case info.Mode()&os.ModeSocket != 0:
+		nodeType = TypeSocket
+	case info.Mode()&os.ModeCharDevice != 0:
+		nodeType = TypeCharDevice
+	case info.Mode()&os.ModeDevice != 0:
+		nodeType = TypeBlockDevice
+	case !info.Mode().IsRegular():
+		panic(fmt.Errorf("unsupported file type %s: %s", info.Mode().Type(), src))
+	}
+
+	child := n.findChild(name)
+	if child == nil {
+		child = n.newChild(name, nodeType, &NodeInformation{Name: name})
+	} else if child.Type != nodeType {
+		switch {
+		case nodeType == TypeDirectory:
+			panic(fmt.Errorf("%w: %s", ErrNotDirectory, child.BuildPathBackwards()))
+		case child.Type == TypeDirectory:
+			panic(fmt.Errorf("%w: %s", ErrIsDirectory, child.BuildPathBackwards()))
+		}
+		path := child.BuildPathBackwards()
+		panic(fmt.Errorf("%w: %s is a %s, %s a %s", ErrTypeMismatch, path, child.Type, src, nodeType))
+	}
+
+	child.applySource(src, info, &NodeInformation{Owner: owner})
+
+	if nodeType == TypeSymlink {
+		target, err := os.Readlink(src)
+		if err != nil {
+			panic(err)
+		}
+		child.Target = target
+	}
+
+	if nodeType == TypeCharDevice || nodeType == TypeBlockDevice {
+		major, minor := utils.GetFileDevice(info)
+		child.Device = &Device{Major: major, Minor: minor}
+	}
+
+	if nodeType == TypeDirectory {
+		child.copyChildren(src, owner)
+	}
+
+	return child
+}
+
+func (n *Node) copyChildren(src, owner string) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(src, entry.Name())
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			panic(err)
+		}
+
+		n.copyEntry(entry.Name(), path, info, owner)
+	}
+}
+
+func (n *Node) applySource(src string, info os.FileInfo, override *NodeInformation) {
+	n.Source = src
+
+	if override.Xattrs != nil {
+		n.Xattrs = override.Xattrs
+	}
+	if override.ACL != nil {
+		n.ACL = override.ACL
+	}
+
+	if override.ModTime != nil {
+		n.ModTime = override.ModTime
+	} else {
+		modTime := info.ModTime()
+		n.ModTime = &modTime
+	}
+
+	if override.Permission != nil {
+		n.Permission = *override.Permission
+	} else {
+		n.Permission = info.Mode() & modeBits
+	}
+
+	if override.Owner != "" {
+		n.Owner = override.Owner
+		n.ownerSet = true
+	} else if os.Geteuid() == 0 {
+		if _, owner := utils.GetFileOwner(utils.HostUsers{}, info); owner != "" {
+			n.Owner = owner
+			n.ownerSet = true
+		}
+	}
+}
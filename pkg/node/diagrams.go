@@ -0,0 +1,219 @@
+package node
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// diagramNode is a line of the tree: a node with its collapsed chain.
+type diagramNode struct {
+	id     string
+	parent string
+	label  string
+	node   *Node
+	cols   columns
+}
+
+// diagramNodes flattens the collapsed tree, parents first.
+func (n *Node) diagramNodes() []diagramNode {
+	nodes := make([]diagramNode, 0)
+
+	var walk func(node *Node, parent string)
+	walk = func(node *Node, parent string) {
+		label, node := node.Collapse()
+		if node.Type == TypeDirectory && strings.HasSuffix(label, "/") {
+			label = strings.TrimSuffix(label, "/")
+		}
+
+		id := fmt.Sprintf("n%d", len(nodes))
+		nodes = append(nodes, diagramNode{id, parent, label + node.describe(), node, node.columns()})
+
+		for _, child := range node.Children {
+			walk(child, id)
+		}
+	}
+	walk(n, "")
+
+	return nodes
+}
+
+// tooltip describes the columns of the long tree in a single line.
+func (d diagramNode) tooltip() string {
+	parts := []string{d.cols.mode, d.cols.owner, d.cols.status.String()}
+	if d.cols.elevated {
+		parts = append(parts, "needs elevation")
+	}
+	if d.node.ModTime != nil {
+		parts = append(parts, "mtime "+d.node.ModTime.Format("2006-01-02 15:04:05"))
+	}
+	if d.node.Source != "" {
+		parts = append(parts, "copy of "+d.node.Source)
+	}
+	return strings.Join(parts, ", ")
+}
+
+var dotFillColors = map[Status]string{
+	StatusNew:      "palegreen",
+	StatusExists:   "gray92",
+	StatusConflict: "salmon",
+	StatusChmod:    "khaki",
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func (n *Node) writeDot(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph mess {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=filled, fontname=\"monospace\"];\n")
+
+	for _, d := range n.diagramNodes() {
+		shape := "box"
+		switch d.node.Type {
+		case TypeDirectory:
+			shape = "folder"
+		case TypeFile:
+			shape = "note"
+		case TypeSymlink:
+			shape = "cds"
+		default:
+			shape = "hexagon"
+		}
+
+		fmt.Fprintf(&b, "\t%s [label=%s, shape=%s, fillcolor=%s, tooltip=%s];\n",
+			d.id, dotQuote(d.label), shape, dotFillColors[d.cols.status], dotQuote(d.tooltip()))
+		if d.parent != "" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", d.parent, d.id)
+		}
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mermaidQuote(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "#", "#35;", "<", "#lt;", ">", "#gt;", "\n", " ")
+	return `"` + r.Replace(s) + `"`
+}
+
+var mermaidClasses = map[Status]string{
+	StatusNew:      "fill:#d4f7d4,stroke:#2e7d32",
+	StatusExists:   "fill:#eeeeee,stroke:#9e9e9e",
+	StatusConflict: "fill:#ffcdd2,stroke:#c62828",
+	StatusChmod:    "fill:#fff3c4,stroke:#f9a825",
+}
+
+func (n *Node) writeMermaid(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("flowchart LR\n")
+
+	byStatus := make(map[Status][]string)
+	for _, d := range n.diagramNodes() {
+		label := mermaidQuote(d.label)
+		switch d.node.Type {
+		case TypeDirectory:
+			label = "[" + label + "]"
+		case TypeFile:
+			label = "(" + label + ")"
+		case TypeSymlink:
+			label = ">" + label + "]"
+		default:
+			label = "{{" + label + "}}"
+		}
+
+		if d.parent != "" {
+			fmt.Fprintf(&b, "    %s --> %s%s\n", d.parent, d.id, label)
+		} else {
+			fmt.Fprintf(&b, "    %s%s\n", d.id, label)
+		}
+		byStatus[d.cols.status] = append(byStatus[d.cols.status], d.id)
+	}
+
+	for _, status := range []Status{StatusNew, StatusExists, StatusConflict, StatusChmod} {
+		ids := byStatus[status]
+		if len(ids) == 0 {
+			continue
+		}
+		class := strings.ReplaceAll(status.String(), "-", "")
+		fmt.Fprintf(&b, "    classDef %s %s\n", class, mermaidClasses[status])
+		fmt.Fprintf(&b, "    class %s %s\n", strings.Join(ids, ","), class)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>mess plan</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+ul { list-style: none; padding-left: 1.5em; margin: 0; border-left: 1px dotted #bbb; }
+li > span, summary { cursor: default; padding: 0 .25em; border-radius: 3px; }
+summary { cursor: pointer; }
+.new { background: #d4f7d4; }
+.exists { color: #777; }
+.conflict { background: #ffcdd2; }
+.will-chmod { background: #fff3c4; }
+.elevated::after { content: " !"; color: #c77700; font-weight: bold; }
+</style>
+</head>
+<body>
+`
+
+const htmlFooter = `</body>
+</html>
+`
+
+func (n *Node) writeHTML(w io.Writer) error {
+	nodes := n.diagramNodes()
+	children := make(map[string][]diagramNode)
+	for _, d := range nodes[1:] {
+		children[d.parent] = append(children[d.parent], d)
+	}
+
+	var b strings.Builder
+	b.WriteString(htmlHeader)
+
+	var write func(d diagramNode)
+	write = func(d diagramNode) {
+		class := d.cols.status.String()
+		if d.cols.elevated {
+			class += " elevated"
+		}
+		attrs := fmt.Sprintf(`class="%s" title="%s"`, class, html.EscapeString(d.tooltip()))
+		label := html.EscapeString(d.label)
+
+		b.WriteString("<li>")
+		if kids := children[d.id]; len(kids) > 0 {
+			fmt.Fprintf(&b, "<details open><summary %s>%s</summary>\n<ul>\n", attrs, label)
+			for _, kid := range kids {
+				write(kid)
+			}
+			b.WriteString("</ul>\n</details>")
+		} else {
+			fmt.Fprintf(&b, "<span %s>%s</span>", attrs, label)
+		}
+		b.WriteString("</li>\n")
+	}
+
+	b.WriteString("<ul>\n")
+	write(nodes[0])
+	b.WriteString("</ul>\n")
+
+	b.WriteString(htmlFooter)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
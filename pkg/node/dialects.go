@@ -0,0 +1,394 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+type Dialect string
+
+const (
+	DialectSh         Dialect = "sh"
+	DialectBash       Dialect = "bash"
+	DialectFish       Dialect = "fish"
+	DialectPowerShell Dialect = "powershell"
+	DialectCmd        Dialect = "cmd"
+	DialectMake       Dialect = "make"
+	DialectDockerfile Dialect = "dockerfile"
+)
+
+var Dialects = []Dialect{DialectSh, DialectBash, DialectFish, DialectPowerShell, DialectCmd, DialectMake, DialectDockerfile}
+
+var (
+	ErrUnknownDialect  = errors.New("unknown echo dialect")
+	ErrUnsupportedName = errors.New("name cannot be written in this dialect")
+)
+
+func ParseDialect(s string) (Dialect, error) {
+	for _, dialect := range Dialects {
+		if string(dialect) == s {
+			return dialect, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownDialect, s)
+}
+
+// WriteCommands renders ops as a script in the given dialect.
+func WriteCommands(w io.Writer, ops []Operation, dialect Dialect) error {
+	var lines []string
+	var err error
+
+	switch dialect {
+	case DialectSh:
+		lines = shellLines(ops, utils.ShellQuote)
+	case DialectBash:
+		lines = shellLines(ops, bashQuote)
+	case DialectFish:
+		lines = shellLines(ops, fishQuote)
+	case DialectPowerShell:
+		lines = powerShellLines(ops)
+	case DialectCmd:
+		lines, err = cmdLines(ops)
+	case DialectMake:
+		lines, err = makeLines(ops)
+	case DialectDockerfile:
+		lines, err = dockerfileLines(ops)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownDialect, dialect)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Args returns the POSIX command line that performs op, without sudo.
+func (op Operation) Args() []string {
+	node := op.Node
+
+	switch op.Kind {
+	case OpMkdir:
+		return []string{"mkdir", "-p", "--", op.Path}
+	case OpCreate:
+		return createArgs(node, op.Path)
+	case OpCopy:
+		return []string{"cp", "-a", "--", node.Source, op.Path}
+	case OpChmod:
+		return []string{"chmod", fmt.Sprintf("%o", octalBits(node.Permission)), "--", op.Path}
+	case OpChown:
+		if node.Type == TypeSymlink {
+			return []string{"chown", "-h", node.Owner, "--", op.Path}
+		}
+		return []string{"chown", node.Owner, "--", op.Path}
+	case OpSetXattr:
+		return []string{"setfattr", "-n", op.Name, "-v", op.Value, "--", op.Path}
+	case OpSetACL:
+		return []string{"setfacl", "-m", strings.Join(node.ACL, ","), "--", op.Path}
+	case OpSetTime:
+		date := node.ModTime.UTC().Format("2006-01-02T15:04:05Z")
+		if node.Type == TypeSymlink {
+			return []string{"touch", "-h", "-d", date, "--", op.Path}
+		}
+		return []string{"touch", "-d", date, "--", op.Path}
+	}
+	return nil
+}
+
+func createArgs(node *Node, fullPath string) []string {
+	switch node.Type {
+	case TypeFIFO:
+		return []string{"mkfifo", "--", fullPath}
+	case TypeSocket:
+		return []string{"python3", "-c", "import socket, sys; socket.socket(socket.AF_UNIX).bind(sys.argv[1])", fullPath}
+	case TypeCharDevice:
+		return []string{"mknod", "--", fullPath, "c", fmt.Sprint(node.Device.Major), fmt.Sprint(node.Device.Minor)}
+	case TypeBlockDevice:
+		return []string{"mknod", "--", fullPath, "b", fmt.Sprint(node.Device.Major), fmt.Sprint(node.Device.Minor)}
+	}
+
+	if node.Size > 0 {
+		switch node.Fill {
+		case FillZero:
+			return []string{"dd", "if=/dev/zero", "of=" + fullPath, "bs=1M", fmt.Sprintf("count=%d", node.Size), "iflag=count_bytes", "status=none"}
+		case FillRandom:
+			return []string{"dd", "if=/dev/urandom", "of=" + fullPath, "bs=1M", fmt.Sprintf("count=%d", node.Size), "iflag=count_bytes", "status=none"}
+		default:
+			return []string{"truncate", "-s", fmt.Sprint(node.Size), "--", fullPath}
+		}
+	}
+
+	return []string{"touch", "--", fullPath}
+}
+
+func quoteArgs(args []string, quote func(string) string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellLines(ops []Operation, quote func(string) string) []string {
+	lines := make([]string, 0, len(ops))
+	for _, op := range ops {
+		line := quoteArgs(op.Args(), quote)
+		if op.Elevated {
+			line = "sudo " + line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// bashQuote uses ANSI-C quoting for words with control characters so that
+// every command stays on a single line.
+func bashQuote(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }) == -1 {
+		return utils.ShellQuote(s)
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for _, c := range []byte(s) {
+		switch {
+		case c == '\\' || c == '\'':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	if s != "" && utils.ShellQuote(s) == s {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// powerShellQuote doubles every kind of single quote PowerShell accepts.
+func powerShellQuote(s string) string {
+	return "'" + strings.NewReplacer("'", "''", "‘", "‘‘", "’", "’’", "‚", "‚‚", "‛", "‛‛").Replace(s) + "'"
+}
+
+func powerShellLines(ops []Operation) []string {
+	lines := make([]string, 0, len(ops)+1)
+	for _, op := range ops {
+		if op.Elevated {
+			lines = append(lines, "#Requires -RunAsAdministrator")
+			break
+		}
+	}
+
+	// Only plain words are left bare: `,`, `@`, `%` and more mean something
+	// to PowerShell even in arguments of native commands.
+	native := func(args []string) string {
+		return "& " + quoteArgs(args, func(s string) string {
+			if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./-") == "" {
+				return s
+			}
+			return powerShellQuote(s)
+		})
+	}
+
+	for _, op := range ops {
+		path := powerShellQuote(op.Path)
+		node := op.Node
+
+		switch {
+		case op.Kind == OpMkdir:
+			lines = append(lines, fmt.Sprintf("New-Item -ItemType Directory -Force -Path %s | Out-Null", path))
+		case op.Kind == OpCopy:
+			lines = append(lines, fmt.Sprintf("Copy-Item -LiteralPath %s -Destination %s", powerShellQuote(node.Source), path))
+		case op.Kind == OpCreate && node.Type == TypeFile && node.Size > 0 && node.Fill == FillRandom:
+			lines = append(lines, fmt.Sprintf("$bytes = [byte[]]::new(%d); [System.Random]::new().NextBytes($bytes); [System.IO.File]::WriteAllBytes(%s, $bytes)", node.Size, path))
+		case op.Kind == OpCreate && node.Type == TypeFile && node.Size > 0:
+			lines = append(lines, fmt.Sprintf("$file = [System.IO.File]::Create(%s); $file.SetLength(%d); $file.Dispose()", path, node.Size))
+		case op.Kind == OpCreate && node.Type == TypeFile:
+			lines = append(lines, fmt.Sprintf("New-Item -ItemType File -Force -Path %s | Out-Null", path))
+		case op.Kind == OpSetTime:
+			lines = append(lines, fmt.Sprintf("$item = Get-Item -Force -LiteralPath %s; $item.LastWriteTimeUtc = $item.LastAccessTimeUtc = [DateTimeOffset]::FromUnixTimeSeconds(%d).UtcDateTime", path, node.ModTime.Unix()))
+		default:
+			lines = append(lines, native(op.Args()))
+		}
+	}
+	return lines
+}
+
+func cmdQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\"\r\n") {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedName, s)
+	}
+	return `"` + strings.ReplaceAll(s, "%", "%%") + `"`, nil
+}
+
+func cmdLines(ops []Operation) ([]string, error) {
+	lines := make([]string, 0, len(ops)+1)
+	for _, op := range ops {
+		if op.Elevated {
+			lines = append(lines, "REM Run from an elevated prompt")
+			break
+		}
+	}
+
+	for _, op := range ops {
+		path, err := cmdQuote(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		node := op.Node
+
+		switch {
+		case op.Kind == OpMkdir:
+			lines = append(lines, fmt.Sprintf("if not exist %s mkdir %s", path, path))
+		case op.Kind == OpCopy && node.Type != TypeSymlink:
+			source, err := cmdQuote(node.Source)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, fmt.Sprintf("copy /Y %s %s > nul", source, path))
+		case op.Kind == OpCreate && node.Type == TypeFile && node.Size > 0:
+			if node.Fill == FillRandom {
+				lines = append(lines, fmt.Sprintf("REM %s is filled with zeros, cmd cannot write random bytes", path))
+			}
+			lines = append(lines, fmt.Sprintf("fsutil file createnew %s %d > nul", path, node.Size))
+		case op.Kind == OpCreate && node.Type == TypeFile:
+			lines = append(lines, fmt.Sprintf("type nul > %s", path))
+		case op.Kind == OpChown:
+			owner, err := cmdQuote(node.Owner)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, fmt.Sprintf("icacls %s /setowner %s > nul", path, owner))
+		default:
+			lines = append(lines, fmt.Sprintf("REM %s %s is not supported by cmd", op.Kind, path))
+		}
+	}
+	return lines, nil
+}
+
+var makeTargetEscaper = strings.NewReplacer(
+	"$", "$$", `\`, `\\`, " ", `\ `, "#", `\#`, ":", `\:`, "%", `\%`, ";", `\;`,
+	"=", `\=`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`,
+)
+
+func makeTarget(path string) (string, error) {
+	if strings.ContainsAny(path, "\t\r\n") {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedName, path)
+	}
+	return makeTargetEscaper.Replace(path), nil
+}
+
+func makeRecipe(op Operation) (string, error) {
+	line := quoteArgs(op.Args(), utils.ShellQuote)
+	if strings.ContainsAny(line, "\r\n") {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedName, op.Path)
+	}
+	if op.Elevated {
+		line = "sudo " + line
+	}
+	return "\t" + strings.ReplaceAll(line, "$", "$$"), nil
+}
+
+// makeLines writes one target per created path. Each target depends
+// order-only on the directory target it lives in, changes to paths that
+// already exist and timestamps go in the recipe of `all`.
+func makeLines(ops []Operation) ([]string, error) {
+	var (
+		order   []string
+		recipes = make(map[string][]string)
+		dirs    = make(map[string]bool)
+		rest    []string
+	)
+
+	for _, op := range ops {
+		switch op.Kind {
+		case OpMkdir:
+			dirs[op.Path] = true
+			fallthrough
+		case OpCreate, OpCopy:
+			order = append(order, op.Path)
+			recipes[op.Path] = nil
+		}
+	}
+
+	for _, op := range ops {
+		recipe, err := makeRecipe(op)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := recipes[op.Path]; ok && op.Kind != OpSetTime {
+			recipes[op.Path] = append(recipes[op.Path], recipe)
+		} else {
+			rest = append(rest, recipe)
+		}
+	}
+
+	targets := make([]string, len(order))
+	for i, path := range order {
+		target, err := makeTarget(path)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = target
+	}
+
+	lines := []string{".PHONY: all", "all: " + strings.Join(targets, " ")}
+	lines = append(lines, rest...)
+
+	for i, path := range order {
+		rule := targets[i] + ":"
+		for parent := filepath.Dir(path); parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+			if dirs[parent] {
+				target, _ := makeTarget(parent)
+				rule += " | " + target
+				break
+			}
+		}
+
+		lines = append(lines, "", rule)
+		lines = append(lines, recipes[path]...)
+	}
+	return lines, nil
+}
+
+func dockerfileLines(ops []Operation) ([]string, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	lines := []string{"RUN set -eu; \\"}
+	for i, op := range ops {
+		line := quoteArgs(op.Args(), utils.ShellQuote)
+		if strings.ContainsAny(line, "\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedName, op.Path)
+		}
+
+		if i < len(ops)-1 {
+			line += "; \\"
+		}
+		lines = append(lines, "    "+line)
+	}
+	return lines, nil
+}
@@ -0,0 +1,103 @@
+package node
+
+import (
+	"bytes"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+// hostileNames are file names a shell would split, expand or take as options
+// unless they are quoted right.
+var hostileNames = []string{
+	"with space",
+	"it's",
+	`"double"`,
+	"$HOME",
+	"$(echo pwned)",
+	"`echo pwned`",
+	"-rf",
+	"--",
+	"new\nline",
+	"*",
+	"?",
+	"tab\there",
+	`back\slash`,
+	"semi;colon",
+	"pipe|less",
+	"amp&",
+	"~",
+	"!bang",
+}
+
+func TestWriteCommandsShHostileNames(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run the commands with")
+	}
+
+	dir := t.TempDir()
+	base := NewWithFS(dir, fsys.OS{})
+	for _, name := range hostileNames {
+		base.AddFile(name)
+		base.AddFile("-dir " + name + "/" + name)
+	}
+	tree := base.Root()
+	tree.Probe(1)
+
+	var commands bytes.Buffer
+	if err := WriteCommands(&commands, tree.Operations(), DialectSh); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(sh, "-eu")
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(commands.Bytes())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("commands failed: %v\n%s\n%s", err, out, commands.String())
+	}
+
+	planned := make([]string, 0)
+	for _, node := range base.flatten() {
+		if node != base {
+			path, _ := filepath.Rel(dir, node.BuildPathBackwards())
+			planned = append(planned, path)
+		}
+	}
+
+	created := make([]string, 0)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			created = append(created, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(planned)
+	slices.Sort(created)
+	if !slices.Equal(planned, created) {
+		t.Fatalf("created %q, planned %q\n%s", created, planned, commands.String())
+	}
+
+	tree.unprobe()
+	for _, node := range base.flatten() {
+		info := node.disk().info
+		if info == nil {
+			t.Fatalf("%q: %v", node.BuildPathBackwards(), node.disk().err)
+		}
+		if info.IsDir() != (node.Type == TypeDirectory) {
+			t.Errorf("%q: directory on disk is %t", node.BuildPathBackwards(), info.IsDir())
+		}
+	}
+}
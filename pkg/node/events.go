@@ -0,0 +1,81 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventPlanned    EventKind = "planned"
+	EventMkdir      EventKind = "mkdir"
+	EventCreate     EventKind = "create"
+	EventChown      EventKind = "chown"
+	EventChmod      EventKind = "chmod"
+	EventAttributes EventKind = "attributes"
+	EventTime       EventKind = "time"
+	EventSync       EventKind = "sync"
+	EventSkip       EventKind = "skip"
+	EventConflict   EventKind = "conflict"
+	EventError      EventKind = "error"
+	EventRollback   EventKind = "rollback"
+)
+
+// Event reports a step of BuildFiles. Duration is only set for steps that
+// touched the disk.
+type Event struct {
+	Event    EventKind     `json:"event"`
+	Path     string        `json:"path"`
+	Type     string        `json:"type,omitempty"`
+	Source   string        `json:"source,omitempty"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// EventFunc receives the events of BuildFiles as they happen.
+type EventFunc func(Event)
+
+type EventsFormat string
+
+const EventsNDJSON EventsFormat = "ndjson"
+
+var ErrUnknownEventsFormat = errors.New("unknown events format")
+
+func ParseEventsFormat(s string) (EventsFormat, error) {
+	if EventsFormat(s) != EventsNDJSON {
+		return "", fmt.Errorf("%w: %q", ErrUnknownEventsFormat, s)
+	}
+	return EventsNDJSON, nil
+}
+
+// NDJSONEvents writes every event to w as a line of JSON. Write errors are
+// ignored so a closed reader doesn't stop the build.
+func NDJSONEvents(w io.Writer) EventFunc {
+	enc := json.NewEncoder(w)
+	return func(e Event) {
+		_ = enc.Encode(e)
+	}
+}
+
+func (sn simpleNode) event(kind EventKind, start time.Time, err error) Event {
+	now := time.Now()
+	e := Event{
+		Event:  kind,
+		Path:   sn.fpath,
+		Type:   schemaTypes[sn.ntype],
+		Source: sn.source,
+		Time:   now,
+	}
+	if !start.IsZero() {
+		e.Duration = now.Sub(start)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
@@ -0,0 +1,295 @@
+package node
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// yamlField is a key of a YAML block mapping. Values are written as JSON,
+// which YAML reads as flow scalars and sequences.
+type yamlField struct {
+	key   string
+	value any
+}
+
+func writeYAMLMapping(w io.Writer, indent string, fields []yamlField) error {
+	for _, field := range fields {
+		if nested, ok := field.value.([]yamlField); ok {
+			if _, err := fmt.Fprintf(w, "%s%s:\n", indent, field.key); err != nil {
+				return err
+			}
+			if err := writeYAMLMapping(w, indent+"  ", nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := jsonScalar(field.value)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", indent, field.key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeYAMLList writes items as a block sequence of mappings.
+func writeYAMLList(w io.Writer, indent string, items [][]yamlField) error {
+	for _, item := range items {
+		if len(item) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		if err := writeYAMLMapping(&b, indent+"  ", item); err != nil {
+			return err
+		}
+
+		block := b.String()
+		block = indent + "- " + strings.TrimPrefix(block, indent+"  ")
+		if _, err := io.WriteString(w, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonScalar encodes v on a single line without escaping HTML characters.
+func jsonScalar(v any) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func octalMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", octalBits(mode))
+}
+
+func (n *Node) ansibleTime() []yamlField {
+	if n.ModTime == nil {
+		return []yamlField{{"modification_time", "preserve"}, {"access_time", "preserve"}}
+	}
+
+	stamp := n.ModTime.Format("200601021504.05")
+	return []yamlField{{"modification_time", stamp}, {"access_time", stamp}}
+}
+
+func ansibleTask(node *Node, name, module string, params []yamlField) []yamlField {
+	task := []yamlField{{"name", name}, {module, params}}
+	if node.NeedsElevation {
+		task = append(task, yamlField{"become", true})
+	}
+	return task
+}
+
+func (n *Node) writeAnsible(w io.Writer) error {
+	tasks := make([][]yamlField, 0)
+	var timed []*Node
+
+	for _, node := range n.plannedNodes() {
+		path := ExpandUserHome(node.BuildPathBackwards())
+		owner := []yamlField{{"owner", node.Owner}}
+		if group := node.group(); group != "-" {
+			owner = append(owner, yamlField{"group", group})
+		}
+
+		switch {
+		case node.Type == TypeDirectory:
+			params := append([]yamlField{{"path", path}, {"state", "directory"}, {"mode", octalMode(node.Permission)}}, owner...)
+			tasks = append(tasks, ansibleTask(node, "Create directory "+path, "ansible.builtin.file", params))
+
+		case node.Type == TypeSymlink:
+			params := append([]yamlField{{"src", node.Target}, {"dest", path}, {"state", "link"}, {"follow", false}}, owner...)
+			tasks = append(tasks, ansibleTask(node, "Link "+path, "ansible.builtin.file", params))
+
+		case node.Type == TypeFile && node.Source != "":
+			params := append([]yamlField{{"src", node.Source}, {"dest", path}, {"mode", octalMode(node.Permission)}}, owner...)
+			tasks = append(tasks, ansibleTask(node, "Copy "+path, "ansible.builtin.copy", params))
+
+		case node.Type == TypeFile && node.Size == 0:
+			params := append([]yamlField{{"path", path}, {"state", "touch"}, {"mode", octalMode(node.Permission)}}, owner...)
+			if node.ModTime == nil {
+				params = append(params, node.ansibleTime()...)
+			}
+			tasks = append(tasks, ansibleTask(node, "Create file "+path, "ansible.builtin.file", params))
+
+		default:
+			command := []yamlField{{"argv", createArgs(node, path)}, {"creates", path}}
+			tasks = append(tasks, ansibleTask(node, fmt.Sprintf("Create %s %s", node.Type, path), "ansible.builtin.command", command))
+
+			params := append([]yamlField{{"path", path}, {"state", "file"}, {"mode", octalMode(node.Permission)}}, owner...)
+			tasks = append(tasks, ansibleTask(node, "Set attributes of "+path, "ansible.builtin.file", params))
+		}
+
+		for _, xattr := range node.xattrList() {
+			name, value, _ := strings.Cut(xattr, "=")
+			namespace, key, _ := strings.Cut(name, ".")
+			params := []yamlField{{"path", path}, {"namespace", namespace}, {"key", key}, {"value", value}, {"follow", false}}
+			tasks = append(tasks, ansibleTask(node, fmt.Sprintf("Set %s on %s", name, path), "community.general.xattr", params))
+		}
+
+		for _, entry := range node.ACL {
+			rest, isDefault := strings.CutPrefix(entry, "default:")
+			parts := strings.SplitN(rest, ":", 3)
+			params := []yamlField{
+				{"path", path}, {"etype", parts[0]}, {"entity", parts[1]}, {"permissions", parts[2]},
+				{"default", isDefault}, {"state", "present"},
+			}
+			tasks = append(tasks, ansibleTask(node, fmt.Sprintf("Set acl %s on %s", entry, path), "ansible.posix.acl", params))
+		}
+
+		if node.ModTime != nil {
+			timed = append(timed, node)
+		}
+	}
+
+	// Timestamps are set deepest first once everything exists.
+	for _, node := range slices.Backward(timed) {
+		path := ExpandUserHome(node.BuildPathBackwards())
+
+		state := "file"
+		switch node.Type {
+		case TypeDirectory:
+			state = "directory"
+		case TypeSymlink:
+			state = "link"
+		}
+
+		params := append([]yamlField{{"path", path}, {"state", state}, {"follow", false}}, node.ansibleTime()...)
+		tasks = append(tasks, ansibleTask(node, "Set timestamps of "+path, "ansible.builtin.file", params))
+	}
+
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		_, err := io.WriteString(w, "[]\n")
+		return err
+	}
+	return writeYAMLList(w, "", tasks)
+}
+
+// writeCloudInit puts regular files in write_files, with the content of copies
+// inlined, and runs everything else as runcmd argument lists.
+func (n *Node) writeCloudInit(w io.Writer) error {
+	files := make([][]yamlField, 0)
+	written := make(map[string]bool)
+
+	for _, node := range n.plannedNodes() {
+		if node.Type != TypeFile || node.Size > 0 {
+			continue
+		}
+
+		path := ExpandUserHome(node.BuildPathBackwards())
+		owner := node.Owner
+		if group := node.group(); group != "-" {
+			owner += ":" + group
+		}
+
+		file := []yamlField{{"path", path}, {"permissions", octalMode(node.Permission)}, {"owner", owner}}
+		if node.Source != "" {
+			content, err := os.ReadFile(node.Source)
+			if err != nil {
+				return err
+			}
+			file = append(file, yamlField{"encoding", "b64"}, yamlField{"content", base64.StdEncoding.EncodeToString(content)})
+		} else {
+			file = append(file, yamlField{"content", ""})
+		}
+		if node.Owner != utils.RootUser {
+			file = append(file, yamlField{"defer", true})
+		}
+
+		files = append(files, file)
+		written[path] = true
+	}
+
+	var commands [][]string
+	for _, op := range n.Operations() {
+		if written[op.Path] && (op.Kind == OpCreate || op.Kind == OpCopy || op.Kind == OpChmod || op.Kind == OpChown) {
+			continue
+		}
+
+		args := op.Args()
+		if op.Kind == OpCopy {
+			if op.Node.Type == TypeSymlink {
+				args = []string{"ln", "-s", "--", op.Node.Target, op.Path}
+			} else {
+				args = createArgs(op.Node, op.Path)
+			}
+		}
+		commands = append(commands, args)
+	}
+
+	if _, err := io.WriteString(w, "#cloud-config\n"); err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		if _, err := io.WriteString(w, "write_files:\n"); err != nil {
+			return err
+		}
+		if err := writeYAMLList(w, "  ", files); err != nil {
+			return err
+		}
+	}
+
+	if len(commands) > 0 {
+		if _, err := io.WriteString(w, "runcmd:\n"); err != nil {
+			return err
+		}
+		for _, args := range commands {
+			line, err := jsonScalar(args)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "  - %s\n", line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var nixStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// writeNix wraps the tmpfiles.d lines in systemd.tmpfiles.rules, with the
+// content of copies inlined since the configuration may be built elsewhere.
+func (n *Node) writeNix(w io.Writer) error {
+	lines, err := n.tmpfilesLines(func(*Node) bool { return true }, false, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "{\n  systemd.tmpfiles.rules = [\n"); err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if comment, ok := strings.CutPrefix(line, "# "); ok {
+			line = "    # " + comment + "\n"
+		} else {
+			line = `    "` + nixStringEscaper.Replace(line) + "\"\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "  ];\n}\n")
+	return err
+}
@@ -0,0 +1,105 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+type Format string
+
+const (
+	FormatAnsible   Format = "ansible"
+	FormatCloudInit Format = "cloud-init"
+	FormatNix       Format = "nix"
+	FormatTmpfiles  Format = "tmpfiles"
+	FormatInstall   Format = "install"
+	FormatDot       Format = "dot"
+	FormatMermaid   Format = "mermaid"
+	FormatHTML      Format = "html"
+)
+
+var formats = map[Format]func(n *Node, w io.Writer) error{
+	FormatAnsible:   (*Node).writeAnsible,
+	FormatCloudInit: (*Node).writeCloudInit,
+	FormatNix:       (*Node).writeNix,
+	FormatTmpfiles:  (*Node).writeTmpfiles,
+	FormatInstall:   (*Node).writeInstall,
+	FormatDot:       (*Node).writeDot,
+	FormatMermaid:   (*Node).writeMermaid,
+	FormatHTML:      (*Node).writeHTML,
+}
+
+var Formats = []Format{FormatAnsible, FormatCloudInit, FormatNix, FormatTmpfiles, FormatInstall, FormatDot, FormatMermaid, FormatHTML}
+
+var ErrUnknownFormat = errors.New("unknown output format")
+
+func ParseFormat(s string) (Format, error) {
+	if _, ok := formats[Format(s)]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+	}
+	return Format(s), nil
+}
+
+// Render writes the tree in the given format.
+func (n *Node) Render(w io.Writer, format Format) error {
+	render, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	return render(n, w)
+}
+
+func (n *Node) PrintFormat(format Format) error {
+	return n.Render(os.Stdout, format)
+}
+
+// plannedNodes returns, parents first, the nodes that don't exist on disk yet.
+func (n *Node) plannedNodes() []*Node {
+	nodes := make([]*Node, 0)
+
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if node.Parent != nil && !node.disk().exists() {
+			nodes = append(nodes, node)
+		}
+
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return nodes
+}
+
+// group returns the name of the group of the node, or "-" when unknown.
+func (n *Node) group() string {
+	if group, _, _ := n.groupOf(); group != "" {
+		return group
+	}
+	return "-"
+}
+
+// groupOf returns the group of the node: the one on disk when it exists,
+// the primary group of its owner otherwise. ok is false when the id isn't
+// known and the name is empty when it can't be looked up.
+func (n *Node) groupOf() (name string, gid int, ok bool) {
+	users := n.users()
+	if id, found := n.disk().gid(); found {
+		gid = int(id)
+	} else if _, id, err := utils.LookupOwner(users, n.Owner); err == nil {
+		gid = id
+	} else {
+		return "", 0, false
+	}
+
+	if g, err := users.LookupGroupID(strconv.Itoa(gid)); err == nil {
+		name = g.Name
+	}
+	return name, gid, true
+}
@@ -0,0 +1,62 @@
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+// Jail confines the nodes added to the tree from now on to n and below,
+// following the symlinks already on disk. Nodes breaking out panic with
+// ErrOutsideBase.
+func (n *Node) Jail() {
+	root := n.Root()
+	root.jail = n
+
+	// Left as it is when it can't be resolved, the nodes below would fail
+	// the same way.
+	root.jailPath = ExpandUserHome(n.BuildPathBackwards())
+	if base, err := fsys.Resolve(root.FS(), root.jailPath, true); err == nil {
+		root.jailPath = base
+	}
+}
+
+// Jailed reports whether n is the jail of its tree, or inside it. Trees
+// without a jail contain everything.
+func (n *Node) Jailed() bool {
+	jail := n.Root().jail
+	if jail == nil || jail == n {
+		return true
+	}
+
+	// Paths are kept by every node, so comparing them is cheaper than
+	// walking up to the jail.
+	return strings.HasPrefix(n.BuildPathBackwards(), childPath(jail.BuildPathBackwards(), ""))
+}
+
+// checkJail panics when n is outside the jail of its tree, either in the tree
+// or on disk through a symlink.
+func (n *Node) checkJail() {
+	root := n.Root()
+	if root.jail == nil {
+		return
+	}
+
+	path := ExpandUserHome(n.BuildPathBackwards())
+	if !n.Jailed() {
+		panic(fmt.Errorf("%w: %s", ErrOutsideBase, path))
+	}
+
+	// A planned symlink is created in place, it doesn't lead anywhere yet.
+	resolved, err := fsys.Resolve(root.FS(), path, n.Type != TypeSymlink)
+	if err != nil {
+		panic(err)
+	}
+
+	base := root.jailPath
+	if resolved != base && !strings.HasPrefix(resolved, strings.TrimSuffix(base, "/")+"/") {
+		panic(fmt.Errorf("%w: %s resolves to %s", ErrOutsideBase, path, filepath.Clean(resolved)))
+	}
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"context"
+	"sync"
+)
+
+// jobPool runs the steps of BuildFiles on up to limit goroutines while
+// keeping the reporting order of a sequential build.
+type jobPool struct {
+	ctx    context.Context
+	limit  int
+	events EventFunc
+
+	warnings []error
+}
+
+// step collects what a task reports, to be passed on in order.
+type step struct {
+	events   []Event
+	warnings []error
+}
+
+func (s *step) event(e Event)  { s.events = append(s.events, e) }
+func (s *step) warn(err error) { s.warnings = append(s.warnings, err) }
+
+// run calls task for 0 to n-1, several at once, and returns once they are all
+// done. Events and warnings come out in the order of i, those of a task as
+// soon as it and every task before it are done. The first failure keeps the
+// tasks that haven't started from running; the ones already running still
+// finish and are reported if they succeed. The error with the lowest i is
+// returned.
+func (j *jobPool) run(n int, task func(i int, st *step) error) error {
+	if n == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+
+	steps := make([]step, n)
+	errs := make([]error, n)
+	done := make([]bool, n)
+
+	var (
+		mu    sync.Mutex
+		next  int
+		first error
+	)
+	// finish marks i as done and passes on what every task up to the
+	// first one still running reported.
+	finish := func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		done[i] = true
+		for ; next < n && done[next]; next++ {
+			if first != nil && errs[next] != nil {
+				continue
+			}
+
+			st := &steps[next]
+			for _, e := range st.events {
+				j.events(e)
+			}
+			j.warnings = append(j.warnings, st.warnings...)
+			if errs[next] != nil {
+				first = errs[next]
+			}
+			steps[next] = step{}
+		}
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(j.limit, 1), n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				// What is left after a failure is skipped, while the
+				// tasks themselves see the caller cancelling.
+				if failed := ctx.Err() != nil && j.ctx.Err() == nil; !failed {
+					if err := task(i, &steps[i]); err != nil {
+						errs[i] = err
+						cancel()
+					}
+				}
+				finish(i)
+			}
+		}()
+	}
+	for i := range n {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return first
+}
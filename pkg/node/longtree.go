@@ -0,0 +1,185 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorDim    = "\x1b[2m"
+	colorBold   = "\x1b[1m"
+)
+
+var statusColors = map[Status]string{
+	StatusNew:      colorGreen,
+	StatusExists:   colorDim,
+	StatusConflict: colorRed + colorBold,
+	StatusChmod:    colorYellow,
+}
+
+const elevationMarker = "!"
+
+// columns is everything the long tree prints about a node besides its name.
+type columns struct {
+	mode     string
+	owner    string
+	elevated bool
+	status   Status
+}
+
+type longRow struct {
+	columns
+	name string
+}
+
+func (n *Node) columns() columns {
+	return columns{
+		mode:     n.modeString(),
+		owner:    n.Owner,
+		elevated: n.NeedsElevation,
+		status:   n.Status(),
+	}
+}
+
+// modeString returns the mode of the node the way `ls -l` prints it.
+func (n *Node) modeString() string {
+	kind := map[NodeType]byte{
+		TypeDirectory:   'd',
+		TypeFile:        '-',
+		TypeSymlink:     'l',
+		TypeFIFO:        'p',
+		TypeSocket:      's',
+		TypeCharDevice:  'c',
+		TypeBlockDevice: 'b',
+	}[n.Type]
+
+	perm := n.Permission
+	if n.Type == TypeSymlink {
+		perm = 0o777
+	}
+
+	mode := []byte{kind}
+	mode = append(mode, (perm & 0o777).String()[1:]...)
+
+	special := func(bit, index int, set, unset byte) {
+		if int(octalBits(perm))&bit == 0 {
+			return
+		}
+		if mode[index] == 'x' {
+			mode[index] = set
+		} else {
+			mode[index] = unset
+		}
+	}
+	special(0o4000, 3, 's', 'S')
+	special(0o2000, 6, 's', 'S')
+	special(0o1000, 9, 't', 'T')
+
+	return string(mode)
+}
+
+// collapseUniform is Collapse for the long tree: a chain only folds into one
+// line while its members would print the same columns.
+func (n *Node) collapseUniform() (string, *Node, columns) {
+	name := n.Name
+	cols := n.columns()
+	for len(n.Children) == 1 {
+		child := n.Children[0]
+		childCols := child.columns()
+		if childCols != cols {
+			break
+		}
+		n = child
+		name = filepath.Join(name, n.Name)
+	}
+	return name, n, cols
+}
+
+// PrintLongTree prints the tree like PrintNodeTree, with the mode, owner,
+// elevation and status of every node in aligned columns, followed by a
+// legend. Statuses are coloured when color is set.
+func (n *Node) PrintLongTree(color bool) {
+	n.WriteLongTree(os.Stdout, color)
+}
+
+// WriteLongTree writes the tree the way PrintLongTree prints it.
+func (n *Node) WriteLongTree(w io.Writer, color bool) {
+	var rows []longRow
+
+	rootPath, rootNode, rootCols := n.collapseUniform()
+	if rootNode.Type == TypeDirectory && !strings.HasSuffix(rootPath, "/") {
+		rootPath += "/"
+	}
+	rows = append(rows, longRow{rootCols, rootPath})
+
+	var walk func(node *Node, prefix string, isLast bool)
+	walk = func(node *Node, prefix string, isLast bool) {
+		collapsed, node, cols := node.collapseUniform()
+
+		branch, nextPrefix := "├── ", prefix+"│   "
+		if isLast {
+			branch, nextPrefix = "└── ", prefix+"    "
+		}
+		rows = append(rows, longRow{cols, prefix + branch + collapsed + node.describe()})
+
+		for i, child := range node.Children {
+			walk(child, nextPrefix, i == len(node.Children)-1)
+		}
+	}
+	for i, child := range rootNode.Children {
+		walk(child, "", i == len(rootNode.Children)-1)
+	}
+
+	ownerWidth, statusWidth := 0, 0
+	for _, row := range rows {
+		ownerWidth = max(ownerWidth, utf8.RuneCountInString(row.owner))
+		statusWidth = max(statusWidth, len(row.status.String()))
+	}
+
+	paint := func(s, code string) string {
+		if !color || code == "" {
+			return s
+		}
+		return code + s + colorReset
+	}
+	pad := func(s string, width int) string {
+		return strings.Repeat(" ", width-utf8.RuneCountInString(s))
+	}
+
+	for _, row := range rows {
+		marker := " "
+		if row.elevated {
+			marker = paint(elevationMarker, colorYellow+colorBold)
+		}
+		status := row.status.String()
+
+		fmt.Fprintf(w, "%s  %s%s  %s  %s%s  %s\n",
+			row.mode,
+			row.owner, pad(row.owner, ownerWidth),
+			marker,
+			paint(status, statusColors[row.status]), pad(status, statusWidth),
+			row.name,
+		)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Legend:")
+	legend := []struct{ key, code, text string }{
+		{elevationMarker, colorYellow + colorBold, "needs elevation"},
+		{StatusNew.String(), statusColors[StatusNew], "will be created"},
+		{StatusExists.String(), statusColors[StatusExists], "already on disk, left as is"},
+		{StatusConflict.String(), statusColors[StatusConflict], "on disk as another type, or a symlink to somewhere else"},
+		{StatusChmod.String(), statusColors[StatusChmod], "already on disk with another mode than requested"},
+	}
+	for _, entry := range legend {
+		fmt.Fprintf(w, "  %s%s  %s\n", paint(entry.key, entry.code), pad(entry.key, len(StatusChmod.String())), entry.text)
+	}
+}
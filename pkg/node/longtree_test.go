@@ -0,0 +1,38 @@
+package node
+
+import (
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+// BenchmarkRenderMem renders a tree of 10k nodes planned on fsys.NewMem,
+// probing it again every time.
+func BenchmarkRenderMem(b *testing.B) {
+	base := NewWithFS("/srv", fsys.NewMem())
+	for i := range 100 {
+		dir := "dir" + strconv.Itoa(i) + "/"
+		for j := range 100 {
+			base.AddFile(dir + "file" + strconv.Itoa(j))
+		}
+	}
+	tree := base.Root()
+
+	b.Run("long", func(b *testing.B) {
+		for b.Loop() {
+			tree.unprobe()
+			tree.WriteLongTree(io.Discard, false)
+		}
+	})
+
+	b.Run("sh", func(b *testing.B) {
+		for b.Loop() {
+			tree.unprobe()
+			if err := WriteCommands(io.Discard, tree.Operations(), DialectSh); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,27 +1,51 @@
 package node
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/devkcud/mess/pkg/fsys"
 	"github.com/devkcud/mess/pkg/utils"
+	"golang.org/x/sys/unix"
 )
 
 type simpleNode struct {
 	fpath string
 	owner string
 	perms os.FileMode
+
+	ntype  NodeType
+	source string
+	target string
+	device *Device
+	size   int64
+	fill   FillMode
+	mtime  *time.Time
+	xattrs map[string]string
+	acl    []string
 }
 
 var (
 	ErrNotDirectory = errors.New("path is a file not a directory")
 	ErrIsDirectory  = errors.New("path is a directory not a file")
+
+	ErrOwnerNotApplied = errors.New("not allowed to change the owner")
+	// ErrAppeared is a conflict with something made at a path between
+	// probing it and building there.
+	ErrAppeared = errors.New("path appeared on disk after it was probed")
 )
 
 func (n *Node) Up() *Node {
@@ -33,6 +57,9 @@ func (n *Node) Up() *Node {
 }
 
 func (n *Node) Root() *Node {
+	if n.root != nil {
+		return n.root
+	}
 	if n.Parent != nil {
 		return n.Parent.Root()
 	}
@@ -54,18 +81,27 @@ func ExpandUserHome(path string) string {
 	return path
 }
 
+// BuildPathBackwards returns the full path of n. It is worked out once, from
+// the path of the parent, and kept.
 func (n *Node) BuildPathBackwards() string {
-	path := n.Name
-	current := n
-	for {
-		if current.Parent == nil {
-			break
+	if n.path == "" {
+		if n.Parent == nil {
+			n.path = n.Name
+		} else {
+			n.path = childPath(n.Parent.BuildPathBackwards(), n.Name)
 		}
-		path = filepath.Join(current.Parent.Name, path)
-		current = current.Parent
 	}
+	return n.path
+}
 
-	return path
+// childPath joins the clean path of a directory and the name of something in
+// it. Unlike filepath.Join, it doesn't clean the directory again, which adds
+// up on deep trees.
+func childPath(dir, name string) string {
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		return dir + name
+	}
+	return dir + string(filepath.Separator) + name
 }
 
 func (n *Node) Collapse() (string, *Node) {
@@ -77,9 +113,78 @@ func (n *Node) Collapse() (string, *Node) {
 	return name, n
 }
 
-func (n *Node) BuildFiles() error {
+// flatten lists n and everything below it, parents first.
+func (n *Node) flatten() []*Node {
+	nodes := make([]*Node, 0)
+
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		nodes = append(nodes, node)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return nodes
+}
+
+// BuildOptions changes how BuildFiles runs.
+type BuildOptions struct {
+	// Events receives every step as it happens.
+	Events EventFunc
+	// DryRun only reports what would be created.
+	DryRun bool
+	// SkipConflicts leaves nodes of another type in the way, and everything
+	// below them, alone instead of failing.
+	SkipConflicts bool
+	// BestEffortOwners keeps going when owners can't be changed, like when
+	// staging a tree unprivileged.
+	BestEffortOwners bool
+	// Sync writes files with content to a temporary file renamed in place and
+	// flushes everything created, and the directories holding it, to stable
+	// storage before returning.
+	Sync bool
+	// Jobs is how many steps run at once. Directories are still created
+	// parents first and events come in the same order as with a single job.
+	Jobs int
+	// Rollback removes what was created when a step fails, newest first.
+	Rollback bool
+}
+
+// BuildFiles creates everything in the tree that isn't on disk yet. It stops
+// before the next step once ctx is done.
+func (n *Node) BuildFiles(ctx context.Context, opts BuildOptions) error {
+	events := opts.Events
+	if events == nil {
+		events = func(Event) {}
+	}
+
+	// made are the creations so far, in the order they happened.
+	var made []Event
+	if opts.Rollback {
+		report := events
+		events = func(e Event) {
+			if e.Event == EventMkdir || e.Event == EventCreate {
+				made = append(made, e)
+			}
+			report(e)
+		}
+	}
+
+	f := n.FS()
+	users := n.users()
+	run := &jobPool{ctx: ctx, limit: opts.Jobs, events: events}
+
+	// Probing is what takes the longest on large trees, so the nodes that
+	// weren't probed yet are looked up with every job.
+	n.Probe(opts.Jobs)
+
 	dirs := make([]simpleNode, 0)
 	files := make([]simpleNode, 0)
+	links := make([]simpleNode, 0)
+	// chmods are the nodes already on disk that get the mode asked for.
+	chmods := make([]simpleNode, 0)
 
 	var walk func(node *Node) error
 	walk = func(node *Node) error {
@@ -87,17 +192,51 @@ func (n *Node) BuildFiles() error {
 			fpath: node.BuildPathBackwards(),
 			owner: node.Owner,
 			perms: node.Permission,
+
+			ntype:  node.Type,
+			source: node.Source,
+			target: node.Target,
+			device: node.Device,
+			size:   node.Size,
+			fill:   node.Fill,
+			mtime:  node.ModTime,
+			xattrs: node.Xattrs,
+			acl:    node.ACL,
+		}
+		info, err := node.disk().info, node.disk().err
+
+		if node.Type == TypeSymlink {
+			if err == nil {
+				events(sn.event(EventSkip, time.Time{}, nil))
+				return nil
+			} else if !os.IsNotExist(err) {
+				events(sn.event(EventError, time.Time{}, err))
+				return err
+			}
+			events(sn.event(EventPlanned, time.Time{}, nil))
+			links = append(links, sn)
+			return nil
 		}
 
-		info, err := os.Stat(sn.fpath)
 		if node.Type == TypeDirectory {
 			if err == nil {
 				if !info.IsDir() {
-					return fmt.Errorf("%w: %s", ErrNotDirectory, sn.fpath)
+					err := fmt.Errorf("%w: %s", ErrNotDirectory, sn.fpath)
+					events(sn.event(EventConflict, time.Time{}, err))
+					if opts.SkipConflicts {
+						return nil
+					}
+					return err
+				}
+				events(sn.event(EventSkip, time.Time{}, nil))
+				if node.Status() == StatusChmod {
+					chmods = append(chmods, sn)
 				}
 			} else if !os.IsNotExist(err) {
+				events(sn.event(EventError, time.Time{}, err))
 				return err
 			} else {
+				events(sn.event(EventPlanned, time.Time{}, nil))
 				dirs = append(dirs, sn)
 			}
 
@@ -111,11 +250,22 @@ func (n *Node) BuildFiles() error {
 
 		if err == nil {
 			if info.IsDir() {
-				return fmt.Errorf("%w: %s", ErrIsDirectory, sn.fpath)
+				err := fmt.Errorf("%w: %s", ErrIsDirectory, sn.fpath)
+				events(sn.event(EventConflict, time.Time{}, err))
+				if opts.SkipConflicts {
+					return nil
+				}
+				return err
+			}
+			events(sn.event(EventSkip, time.Time{}, nil))
+			if node.Status() == StatusChmod {
+				chmods = append(chmods, sn)
 			}
 		} else if !os.IsNotExist(err) {
+			events(sn.event(EventError, time.Time{}, err))
 			return err
 		} else {
+			events(sn.event(EventPlanned, time.Time{}, nil))
 			files = append(files, sn)
 		}
 
@@ -126,45 +276,394 @@ func (n *Node) BuildFiles() error {
 		return err
 	}
 
-	for _, dir := range dirs {
-		u, err := user.Lookup(dir.owner)
-		if err != nil {
+	if opts.DryRun {
+		return nil
+	}
+
+	// Whatever happens next, the disk won't match the probes anymore.
+	defer n.unprobe()
+
+	// Children were created after their directories, so going backwards
+	// empties every directory before removing it.
+	built := false
+	if opts.Rollback {
+		defer func() {
+			if built {
+				return
+			}
+			for _, e := range slices.Backward(made) {
+				start := time.Now()
+				removeErr := f.Remove(e.Path)
+
+				e.Event, e.Time, e.Duration, e.Error = EventRollback, time.Now(), time.Since(start), ""
+				if removeErr != nil {
+					e.Error = removeErr.Error()
+				}
+				events(e)
+			}
+		}()
+	}
+
+	// fail reports err as an error event of sn and returns it. Paths that
+	// were free when probed and aren't anymore are conflicts: nothing
+	// someone else put there is taken over.
+	fail := func(st *step, sn simpleNode, err error) error {
+		if errors.Is(err, fs.ErrExist) {
+			err = fmt.Errorf("%w: %s", ErrAppeared, sn.fpath)
+			st.event(sn.event(EventConflict, time.Time{}, err))
 			return err
 		}
+		st.event(sn.event(EventError, time.Time{}, err))
+		return err
+	}
 
-		if err := os.MkdirAll(dir.fpath, dir.perms); err != nil {
-			return fmt.Errorf("%w: %s", err, dir.fpath)
+	chown := func(st *step, sn simpleNode, u *user.User, lchown bool) error {
+		if runtime.GOOS == "windows" {
+			return nil
+		}
+
+		start := time.Now()
+		uid, _ := strconv.ParseInt(u.Uid, 10, 32)
+		gid, _ := strconv.ParseInt(u.Gid, 10, 32)
+
+		change := f.Chown
+		if lchown {
+			change = f.Lchown
+		}
+		if err := change(sn.fpath, int(uid), int(gid)); opts.BestEffortOwners && errors.Is(err, fs.ErrPermission) {
+			err := fmt.Errorf("%w to %s on %s", ErrOwnerNotApplied, sn.owner, sn.fpath)
+			st.event(sn.event(EventSkip, start, err))
+			st.warn(err)
+			return nil
+		} else if err != nil {
+			return fail(st, sn, fmt.Errorf("%w: %s", err, sn.owner))
 		}
+		st.event(sn.event(EventChown, start, nil))
+		return nil
+	}
 
-		if runtime.GOOS != "windows" {
-			uid, _ := strconv.ParseInt(u.Uid, 10, 32)
-			gid, _ := strconv.ParseInt(u.Gid, 10, 32)
+	// each runs task for every node of list, failing once ctx is done.
+	each := func(list []simpleNode, task func(st *step, sn simpleNode) error) error {
+		return run.run(len(list), func(i int, st *step) error {
+			if err := ctx.Err(); err != nil {
+				return fail(st, list[i], err)
+			}
+			return task(st, list[i])
+		})
+	}
 
-			if err := os.Chown(dir.fpath, int(uid), int(gid)); err != nil {
-				return fmt.Errorf("%w: %s", err, dir.owner)
+	// Directories are created level by level, parents first; everything
+	// else only needs them in place.
+	for _, level := range byDepth(dirs, false) {
+		err := each(level, func(st *step, dir simpleNode) error {
+			u, err := users.LookupUser(dir.owner)
+			if err != nil {
+				return fail(st, dir, err)
 			}
+
+			// Parents are either on disk already or in an earlier level.
+			start := time.Now()
+			if err := f.Mkdir(dir.fpath, dir.perms); err != nil {
+				return fail(st, dir, fmt.Errorf("%w: %s", err, dir.fpath))
+			}
+			st.event(dir.event(EventMkdir, start, nil))
+
+			return chown(st, dir, u, false)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	for _, file := range files {
-		u, err := user.Lookup(file.owner)
+	err := each(files, func(st *step, file simpleNode) error {
+		u, err := users.LookupUser(file.owner)
 		if err != nil {
+			return fail(st, file, err)
+		}
+
+		start := time.Now()
+		if err := createFile(f, file, opts.Sync); err != nil {
+			return fail(st, file, fmt.Errorf("%w: %s", err, file.fpath))
+		}
+		st.event(file.event(EventCreate, start, nil))
+
+		return chown(st, file, u, false)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = each(links, func(st *step, link simpleNode) error {
+		u, err := users.LookupUser(link.owner)
+		if err != nil {
+			return fail(st, link, err)
+		}
+
+		start := time.Now()
+		if err := f.Symlink(link.target, link.fpath); err != nil {
+			return fail(st, link, fmt.Errorf("%w: %s", err, link.fpath))
+		}
+		st.event(link.event(EventCreate, start, nil))
+
+		return chown(st, link, u, true)
+	})
+	if err != nil {
+		return err
+	}
+
+	chmod := func(st *step, sn simpleNode) error {
+		start := time.Now()
+		if err := f.Chmod(sn.fpath, sn.perms); err != nil {
+			return fail(st, sn, fmt.Errorf("%w: %s", err, sn.fpath))
+		}
+		st.event(sn.event(EventChmod, start, nil))
+		return nil
+	}
+
+	// Like Operations, what already exists only gets its mode changed, and
+	// only when another one than the default was asked for. What was just
+	// created gets it too when it was asked for or copied, since the umask
+	// narrowed it and a chown may have cleared the setuid and setgid bits.
+	// Directories go deepest first, after their children are in place.
+	defaultMode := func(sn simpleNode) bool {
+		if sn.source != "" {
+			return false
+		}
+		if sn.ntype == TypeDirectory {
+			return sn.perms == utils.DirPerm
+		}
+		return sn.perms == utils.FilePerm
+	}
+	if err := each(slices.Concat(chmods, slices.DeleteFunc(slices.Clone(files), defaultMode)), chmod); err != nil {
+		return err
+	}
+	for _, level := range byDepth(slices.DeleteFunc(slices.Clone(dirs), defaultMode), true) {
+		if err := each(level, chmod); err != nil {
 			return err
 		}
+	}
+
+	created := slices.Concat(dirs, files, links)
+
+	err = run.run(len(created), func(i int, st *step) error {
+		sn := created[i]
+		if len(sn.xattrs) == 0 && len(sn.acl) == 0 {
+			return nil
+		}
+
+		start := time.Now()
+		if err := setAttributes(f, users, sn); errors.Is(err, ErrAttrUnsupported) {
+			st.event(sn.event(EventSkip, start, err))
+			st.warn(fmt.Errorf("%w on %s", err, sn.fpath))
+		} else if err != nil {
+			return fail(st, sn, fmt.Errorf("%w: %s", err, sn.fpath))
+		} else {
+			st.event(sn.event(EventAttributes, start, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if err := os.WriteFile(file.fpath, []byte(""), file.perms); err != nil {
-			return fmt.Errorf("%w: %s", err, file.fpath)
+	// Directories go last and deepest first so that creating their children
+	// doesn't bump the timestamps again.
+	touch := func(st *step, sn simpleNode) error {
+		if sn.mtime == nil {
+			return nil
 		}
 
-		if runtime.GOOS != "windows" {
-			uid, _ := strconv.ParseInt(u.Uid, 10, 32)
-			gid, _ := strconv.ParseInt(u.Gid, 10, 32)
+		start := time.Now()
+		if err := setTimes(f, sn); err != nil {
+			return fail(st, sn, fmt.Errorf("%w: %s", err, sn.fpath))
+		}
+		st.event(sn.event(EventTime, start, nil))
+		return nil
+	}
+	if err := run.run(len(files)+len(links), func(i int, st *step) error {
+		return touch(st, created[len(dirs)+i])
+	}); err != nil {
+		return err
+	}
+	for _, level := range byDepth(dirs, true) {
+		if err := run.run(len(level), func(i int, st *step) error {
+			return touch(st, level[i])
+		}); err != nil {
+			return err
+		}
+	}
 
-			if err := os.Chown(file.fpath, int(uid), int(gid)); err != nil {
-				return fmt.Errorf("%w: %s", err, file.owner)
+	// Flushing goes last, once the metadata is final: regular files first,
+	// then directories deepest first, along with the ones the tree was
+	// created in.
+	if opts.Sync {
+		flush := func(st *step, sn simpleNode) error {
+			start := time.Now()
+			if err := f.Sync(sn.fpath); err != nil {
+				return fail(st, sn, fmt.Errorf("%w: %s", err, sn.fpath))
 			}
+			st.event(sn.event(EventSync, start, nil))
+			return nil
+		}
+
+		regular := slices.DeleteFunc(slices.Clone(files), func(sn simpleNode) bool { return sn.ntype != TypeFile })
+		if err := run.run(len(regular), func(i int, st *step) error {
+			return flush(st, regular[i])
+		}); err != nil {
+			return err
+		}
+
+		parents := make(map[string]bool)
+		for _, sn := range created {
+			parents[filepath.Dir(sn.fpath)] = true
 		}
+		for _, dir := range dirs {
+			parents[dir.fpath] = true
+		}
+		holding := make([]simpleNode, 0, len(parents))
+		for path := range parents {
+			holding = append(holding, simpleNode{fpath: path, ntype: TypeDirectory})
+		}
+		slices.SortFunc(holding, func(a, b simpleNode) int { return strings.Compare(a.fpath, b.fpath) })
+
+		for _, level := range byDepth(holding, true) {
+			if err := run.run(len(level), func(i int, st *step) error {
+				return flush(st, level[i])
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	built = true
+	return errors.Join(run.warnings...)
+}
+
+// byDepth groups list by how deep their paths are, shallowest first or
+// deepest first, keeping the order within a level.
+func byDepth(list []simpleNode, deepestFirst bool) [][]simpleNode {
+	levels := make(map[int][]simpleNode)
+	for _, sn := range list {
+		depth := strings.Count(filepath.Clean(sn.fpath), string(filepath.Separator))
+		levels[depth] = append(levels[depth], sn)
 	}
 
-	return nil
+	depths := slices.Sorted(maps.Keys(levels))
+	if deepestFirst {
+		slices.Reverse(depths)
+	}
+
+	grouped := make([][]simpleNode, 0, len(depths))
+	for _, depth := range depths {
+		grouped = append(grouped, levels[depth])
+	}
+	return grouped
+}
+
+func setTimes(f fsys.FS, sn simpleNode) error {
+	if sn.mtime == nil {
+		return nil
+	}
+
+	if sn.ntype == TypeSymlink {
+		return f.Lchtimes(sn.fpath, *sn.mtime, *sn.mtime)
+	}
+
+	return f.Chtimes(sn.fpath, *sn.mtime, *sn.mtime)
+}
+
+func createFile(f fsys.FS, file simpleNode, sync bool) error {
+	perms := octalBits(file.perms)
+
+	switch file.ntype {
+	case TypeFIFO:
+		return f.Mknod(file.fpath, unix.S_IFIFO|perms, 0)
+	case TypeSocket:
+		return f.Mknod(file.fpath, unix.S_IFSOCK|perms, 0)
+	case TypeCharDevice:
+		return f.Mknod(file.fpath, unix.S_IFCHR|perms, unix.Mkdev(file.device.Major, file.device.Minor))
+	case TypeBlockDevice:
+		return f.Mknod(file.fpath, unix.S_IFBLK|perms, unix.Mkdev(file.device.Major, file.device.Minor))
+	}
+
+	if file.source != "" {
+		return copyFile(f, file.source, file.fpath, file.perms, sync)
+	}
+
+	if file.size > 0 {
+		return sizeFile(f, file.fpath, file.perms, file.size, file.fill, sync)
+	}
+
+	out, err := f.Create(file.fpath, file.perms)
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func sizeFile(f fsys.FS, path string, perms os.FileMode, size int64, fill FillMode, sync bool) error {
+	return writeFile(f, path, perms, sync, func(out fsys.File) error {
+		switch fill {
+		case FillZero:
+			_, err := io.CopyN(out, zeroReader{}, size)
+			return err
+		case FillRandom:
+			_, err := io.CopyN(out, rand.Reader, size)
+			return err
+		default:
+			return out.Truncate(size)
+		}
+	})
+}
+
+// writeFile creates path with the content from write. With sync the content
+// goes to a temporary file next to path, flushed and renamed in place, so
+// path never holds half of it.
+func writeFile(f fsys.FS, path string, perms os.FileMode, sync bool, write func(fsys.File) error) error {
+	name := path
+	if sync {
+		name = filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+strings.ToLower(rand.Text()[:8]))
+	}
+
+	out, err := f.Create(name, perms)
+	if err != nil {
+		return err
+	}
+
+	err = write(out)
+	if err == nil && sync {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && sync {
+		err = f.Rename(name, path)
+	}
+
+	if err != nil && sync {
+		_ = f.Remove(name)
+	}
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// copyFile copies src, which is always read from the disk, to dst on f.
+func copyFile(f fsys.FS, src, dst string, perms os.FileMode, sync bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(f, dst, perms, sync, func(out fsys.File) error {
+		_, err := io.Copy(out, in)
+		return err
+	})
 }
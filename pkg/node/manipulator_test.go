@@ -0,0 +1,74 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+func BenchmarkBuildPathBackwards(b *testing.B) {
+	base := NewWithFS("/srv", fsys.NewMem())
+	base.AddFile(strings.Repeat("dir/", 100) + "file")
+	for i := range 1_000 {
+		base.AddFile("wide/file" + strconv.Itoa(i))
+	}
+	nodes := base.Root().flatten()
+
+	b.Run("cold", func(b *testing.B) {
+		for b.Loop() {
+			for _, node := range nodes {
+				node.path = ""
+			}
+			for _, node := range nodes {
+				node.BuildPathBackwards()
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			for _, node := range nodes {
+				node.BuildPathBackwards()
+			}
+		}
+	})
+}
+
+func TestBuildFilesRollback(t *testing.T) {
+	mem := fsys.NewMem()
+	if err := mem.Mkdir("/srv", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	base := NewWithFS("/srv", mem)
+	file := base.AddFile("a/b/file")
+	file.Owner, file.ownerSet = "mess-no-such-user", true
+
+	var rolledBack []string
+	err := base.Root().BuildFiles(context.Background(), BuildOptions{
+		Rollback: true,
+		Events: func(e Event) {
+			if e.Event == EventRollback {
+				if e.Error != "" {
+					t.Errorf("rolling back %s: %s", e.Path, e.Error)
+				}
+				rolledBack = append(rolledBack, e.Path)
+			}
+		},
+	})
+	if err == nil {
+		t.Fatal("the build didn't fail")
+	}
+
+	if want := []string{"/srv/a/b", "/srv/a"}; !slices.Equal(rolledBack, want) {
+		t.Errorf("rolled back %q, want %q", rolledBack, want)
+	}
+	if _, err := mem.Lstat("/srv/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("/srv/a is still there: %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package node
 import (
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/devkcud/mess/pkg/fsys"
 	"github.com/devkcud/mess/pkg/utils"
 )
 
@@ -18,16 +20,65 @@ type Node struct {
 	NeedsElevation bool        `json:"needs_elevation"`
 	Owner          string      `json:"owner"`
 
+	// ownerSet is true when Owner was asked for rather than guessed.
+	ownerSet bool
+	// probed is what Probe found on disk, nil until then.
+	probed *probe
+	// index finds children by name and path is the one BuildPathBackwards
+	// returns. newChild keeps both up to date.
+	index map[string]*Node
+	path  string
+	// root is the root of the tree, kept by newChild so that finding it
+	// doesn't walk up every level.
+	root *Node
+
+	Source string  `json:"source,omitempty"`
+	Target string  `json:"target,omitempty"`
+	Device *Device `json:"device,omitempty"`
+
+	Size int64    `json:"size,omitempty"`
+	Fill FillMode `json:"fill,omitempty"`
+
+	ModTime *time.Time `json:"mtime,omitempty"`
+
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	ACL    []string          `json:"acl,omitempty"`
+
 	Parent   *Node   `json:"-"`
 	Children []*Node `json:"children"`
+
+	// fs is what the tree is probed and built on and jail, when set, the
+	// node everything has to stay below, at jailPath on disk. Only the root
+	// has them.
+	fs       fsys.FS
+	jail     *Node
+	jailPath string
+	// accounts resolves owners for the whole tree, remembering every lookup.
+	// Only the root has it.
+	accounts utils.Users
 }
 
 const (
 	TypeDirectory NodeType = iota
 	TypeFile
+	TypeSymlink
+	TypeFIFO
+	TypeSocket
+	TypeCharDevice
+	TypeBlockDevice
 )
 
+type Device struct {
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+}
+
 func New(baseDirectory string) *Node {
+	return NewWithFS(baseDirectory, fsys.OS{})
+}
+
+// NewWithFS is New for a tree probed and built on f instead of the disk.
+func NewWithFS(baseDirectory string, f fsys.FS) *Node {
 	if !filepath.IsAbs(baseDirectory) {
 		baseDirectory = filepath.Join(utils.UserHomeDirectory, baseDirectory)
 	}
@@ -42,6 +93,8 @@ func New(baseDirectory string) *Node {
 		Owner:          utils.RootUser,
 		Parent:         nil,
 		Children:       []*Node{},
+		fs:             f,
+		accounts:       utils.NewCachedUsers(fsys.UsersOf(f)),
 	}
 	current := root
 	for _, part := range utils.SplitPath(baseDirectory)[1:] {
@@ -61,6 +114,37 @@ func (nt NodeType) String() (name string) {
 		name = "directory"
 	case TypeFile:
 		name = "file"
+	case TypeSymlink:
+		name = "symlink"
+	case TypeFIFO:
+		name = "fifo"
+	case TypeSocket:
+		name = "socket"
+	case TypeCharDevice:
+		name = "char device"
+	case TypeBlockDevice:
+		name = "block device"
 	}
 	return
 }
+
+// IsSpecial reports whether nt is a FIFO, socket or device node.
+func (nt NodeType) IsSpecial() bool {
+	return nt == TypeFIFO || nt == TypeSocket || nt == TypeCharDevice || nt == TypeBlockDevice
+}
+
+// FS returns the filesystem the tree is probed and built on.
+func (n *Node) FS() fsys.FS {
+	if root := n.Root(); root.fs != nil {
+		return root.fs
+	}
+	return fsys.OS{}
+}
+
+// users returns the accounts owners in the tree are resolved with.
+func (n *Node) users() utils.Users {
+	if root := n.Root(); root.accounts != nil {
+		return root.accounts
+	}
+	return fsys.UsersOf(n.FS())
+}
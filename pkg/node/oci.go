@@ -0,0 +1,171 @@
+package node
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	ociMediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+type ociDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Size      int64        `json:"size"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociConfig struct {
+	ociPlatform
+	Config struct{} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+func digestOf(h hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeBlob stores data under blobs/sha256 in the layout and describes it.
+func writeBlob(layout, mediaType string, data []byte) (ociDescriptor, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	if err := os.WriteFile(filepath.Join(layout, "blobs", "sha256", digest), data, 0o644); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}, nil
+}
+
+// WriteOCILayout builds the descendants of n as a single gzip'ed layer of a
+// minimal OCI image layout in dir and returns the manifest digest. Nothing
+// depends on the current time but nodes without a timestamp, which get now,
+// so the digests are reproducible once every node has one.
+func (n *Node) WriteOCILayout(dir string, now time.Time) (string, error) {
+	entries, err := n.archiveEntries(now)
+	if err != nil {
+		return "", err
+	}
+
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0o755); err != nil {
+		return "", err
+	}
+
+	layer, err := os.CreateTemp(blobs, ".layer-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(layer.Name())
+	defer layer.Close()
+
+	diffID, layerDigest := sha256.New(), sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(layer, layerDigest)}
+	gz := gzip.NewWriter(counter)
+
+	if err := writeTar(io.MultiWriter(gz, diffID), entries); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := layer.Close(); err != nil {
+		return "", err
+	}
+
+	layerDescriptor := ociDescriptor{MediaType: ociMediaTypeLayer, Digest: digestOf(layerDigest), Size: counter.n}
+	if err := os.Rename(layer.Name(), filepath.Join(blobs, hex.EncodeToString(layerDigest.Sum(nil)))); err != nil {
+		return "", err
+	}
+
+	platform := ociPlatform{Architecture: runtime.GOARCH, OS: "linux"}
+
+	config := ociConfig{ociPlatform: platform}
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = []string{digestOf(diffID)}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	configDescriptor, err := writeBlob(dir, ociMediaTypeConfig, configJSON)
+	if err != nil {
+		return "", err
+	}
+
+	manifestJSON, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+		Config:        configDescriptor,
+		Layers:        []ociDescriptor{layerDescriptor},
+	})
+	if err != nil {
+		return "", err
+	}
+	manifestDescriptor, err := writeBlob(dir, ociMediaTypeManifest, manifestJSON)
+	if err != nil {
+		return "", err
+	}
+	manifestDescriptor.Platform = &platform
+
+	indexJSON, err := json.MarshalIndent(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeIndex,
+		Manifests:     []ociDescriptor{manifestDescriptor},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), append(indexJSON, '\n'), 0o644); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`+"\n"), 0o644); err != nil {
+		return "", err
+	}
+
+	return manifestDescriptor.Digest, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
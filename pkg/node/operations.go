@@ -1,6 +1,7 @@
 package node
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/devkcud/mess/pkg/utils"
@@ -28,8 +29,12 @@ func (n *Node) insertChild(path string, nodeType NodeType) *Node {
 			continue
 		}
 
-		if i == 0 {
-			part = ExpandUserHome(part)
+		// The home directory is reached from the root, so a jail catches it
+		// like any other absolute path. A file can't be it, so a lone `~`
+		// file is just named that.
+		if i == 0 && part == "~" && (i < len(parts)-1 || nodeType == TypeDirectory) {
+			current = current.UserHome()
+			continue
 		}
 
 		if part == ".." {
@@ -43,70 +48,93 @@ func (n *Node) insertChild(path string, nodeType NodeType) *Node {
 		if err != nil {
 			panic(err) // TODO: Handle errors better
 		}
-		part = information.Name
 
-		found := false
+		if child := current.findChild(information.Name); child != nil {
+			current = child
+			continue
+		}
 
-		for _, child := range current.Children {
-			if child.Name == part {
-				current = child
-				found = true
-				break
-			}
+		newType := TypeDirectory
+		if i == len(parts)-1 {
+			newType = nodeType
 		}
 
-		if !found {
-			newType := TypeDirectory
-			if i == len(parts)-1 {
-				newType = nodeType
+		if information.Type != nil {
+			if newType != TypeFile {
+				panic(fmt.Errorf("%w: %s", ErrSpecialDirectory, information.Name))
 			}
+			newType = *information.Type
+		}
 
-			perm := utils.FilePerm
-			if newType == TypeDirectory {
-				perm = utils.DirPerm
-			}
+		if information.Size != nil && newType != TypeFile {
+			panic(fmt.Errorf("%w: %s", ErrSizedNonFile, information.Name))
+		}
 
-			if information.Permission != nil {
-				perm = *information.Permission
-			}
+		current = current.newChild(information.Name, newType, information)
+	}
 
-			newNode := &Node{
-				Name:       part,
-				Type:       newType,
-				Permission: perm,
-				Parent:     current,
-				Children:   []*Node{},
-			}
+	if !current.Jailed() {
+		panic(fmt.Errorf("%w: %s", ErrOutsideBase, ExpandUserHome(current.BuildPathBackwards())))
+	}
 
-			path := newNode.BuildPathBackwards()
-			pathExists := utils.DoesPathExist(path)
+	return current
+}
 
-			if pathExists {
-				newNode.NeedsElevation = utils.NeedsElevation(path)
-			} else {
-				newNode.NeedsElevation = newNode.Parent.NeedsElevation
+func (n *Node) findChild(name string) *Node {
+	// Children appended by hand aren't indexed yet.
+	if len(n.index) != len(n.Children) {
+		n.index = make(map[string]*Node, len(n.Children))
+		for _, child := range n.Children {
+			if _, ok := n.index[child.Name]; !ok {
+				n.index[child.Name] = child
 			}
+		}
+	}
+	return n.index[name]
+}
 
-			if pathExists {
-				_, newNode.Owner = utils.GetOwnerInfo(path)
-			} else {
-				if newNode.NeedsElevation {
-					newNode.Owner = utils.RootUser
-				} else {
-					newNode.Owner = utils.CurrentUser
-				}
-			}
+func (n *Node) newChild(name string, nodeType NodeType, information *NodeInformation) *Node {
+	perm := utils.FilePerm
+	if nodeType == TypeDirectory {
+		perm = utils.DirPerm
+	}
 
-			if information.Owner != "" {
-				newNode.Owner = information.Owner
-			}
+	if information.Permission != nil {
+		perm = *information.Permission
+	}
 
-			current.Children = append(current.Children, newNode)
-			current = newNode
-		}
+	newNode := &Node{
+		Name:       name,
+		Type:       nodeType,
+		Permission: perm,
+		Device:     information.Device,
+		ModTime:    information.ModTime,
+		Xattrs:     information.Xattrs,
+		ACL:        information.ACL,
+		Parent:     n,
+		Children:   []*Node{},
+		root:       n.Root(),
 	}
 
-	return current
+	if information.Size != nil {
+		newNode.Size = *information.Size
+		newNode.Fill = information.Fill
+	}
+
+	if information.Owner != "" {
+		newNode.Owner = information.Owner
+		newNode.ownerSet = true
+	}
+
+	newNode.path = childPath(n.BuildPathBackwards(), name)
+	newNode.checkJail()
+
+	n.Children = append(n.Children, newNode)
+	if n.index == nil {
+		n.index = make(map[string]*Node)
+	}
+	n.index[name] = newNode
+	return newNode
 }
 
 func (n *Node) AddFile(file string) *Node {
@@ -116,3 +144,15 @@ func (n *Node) AddFile(file string) *Node {
 func (n *Node) AddDirectory(directory string) *Node {
 	return n.insertChild(directory, TypeDirectory)
 }
+
+// SetDefaultOwner sets owner on every node in the tree that does not exist yet
+// and wasn't given an owner of its own.
+func (n *Node) SetDefaultOwner(owner string) {
+	if !n.ownerSet && !n.disk().exists() {
+		n.Owner = owner
+	}
+
+	for _, child := range n.Children {
+		child.SetDefaultOwner(owner)
+	}
+}
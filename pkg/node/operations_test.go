@@ -0,0 +1,39 @@
+package node
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+func BenchmarkAddFileWide(b *testing.B) {
+	for _, n := range []int{1_000, 100_000, 1_000_000} {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = "file" + strconv.Itoa(i)
+		}
+
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for b.Loop() {
+				base := NewWithFS("/srv", fsys.NewMem())
+				for _, name := range names {
+					base.AddFile(name)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAddFileDeep(b *testing.B) {
+	for _, depth := range []int{100, 1_000} {
+		path := strings.Repeat("dir/", depth) + "file"
+
+		b.Run(strconv.Itoa(depth), func(b *testing.B) {
+			for b.Loop() {
+				NewWithFS("/srv", fsys.NewMem()).AddFile(path)
+			}
+		})
+	}
+}
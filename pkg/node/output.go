@@ -3,27 +3,33 @@ package node
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"slices"
 	"strings"
-
-	"github.com/devkcud/mess/pkg/utils"
 )
 
 func (n *Node) PrintNodeTree() {
+	n.WriteNodeTree(os.Stdout)
+}
+
+// WriteNodeTree writes the tree the way PrintNodeTree prints it.
+func (n *Node) WriteNodeTree(w io.Writer) {
 	rootPath, rootNode := n.Collapse()
 
 	if rootNode.Type == TypeDirectory && !strings.HasSuffix(rootNode.Name, "/") {
 		rootPath += "/"
 	}
 
-	fmt.Println(rootPath)
+	fmt.Fprintln(w, rootPath)
 
 	for i, child := range rootNode.Children {
 		last := i == len(rootNode.Children)-1
-		child.print("", last)
+		child.print(w, "", last)
 	}
 }
 
-func (n *Node) print(prefix string, isLast bool) {
+func (n *Node) print(w io.Writer, prefix string, isLast bool) {
 	collapsed, node := n.Collapse()
 
 	branch := "├── "
@@ -31,11 +37,7 @@ func (n *Node) print(prefix string, isLast bool) {
 		branch = "└── "
 	}
 
-	if node.Type == TypeDirectory {
-		collapsed += "/"
-	}
-
-	fmt.Printf("%s%s%s\n", prefix, branch, collapsed)
+	fmt.Fprintf(w, "%s%s%s\n", prefix, branch, collapsed+node.describe())
 
 	nextPrefix := prefix
 	if isLast {
@@ -46,146 +48,54 @@ func (n *Node) print(prefix string, isLast bool) {
 
 	for i, child := range node.Children {
 		last := i == len(node.Children)-1
-		child.print(nextPrefix, last)
+		child.print(w, nextPrefix, last)
 	}
 }
 
-func (n *Node) PrintCommands() {
-	currentUser := utils.CurrentUser
-
-	var (
-		sudoMkdirs, mkdirs   []string
-		sudoTouches, touches []string
-		sudoChmods, chmods   []string
-		sudoChowns, chowns   []string
-	)
-
-	var walkDirs func(node *Node)
-	walkDirs = func(node *Node) {
-		if node.Type != TypeDirectory {
-			return
-		}
-		if node.Parent != nil && node.Parent.Type == TypeDirectory && len(node.Parent.Children) == 1 {
-			return
-		}
-
-		_, deepest := node.Collapse()
-		if deepest.Type == TypeFile {
-			deepest = deepest.Up()
-		}
-		fullPath := ExpandUserHome(deepest.BuildPathBackwards())
-
-		if deepest.Parent == nil {
-			for _, c := range deepest.Children {
-				walkDirs(c)
-			}
-			return
-		}
-
-		if !utils.DoesPathExist(fullPath) {
-			cmd := fmt.Sprintf("mkdir -p %s", fullPath)
-			if deepest.NeedsElevation {
-				sudoMkdirs = append(sudoMkdirs, "sudo "+cmd)
-			} else {
-				mkdirs = append(mkdirs, cmd)
-			}
-		}
-
-		if deepest.Permission != utils.DirPerm {
-			cmd := fmt.Sprintf("chmod %o %s", deepest.Permission, fullPath)
-			if deepest.NeedsElevation {
-				sudoChmods = append(sudoChmods, "sudo "+cmd)
-			} else {
-				chmods = append(chmods, cmd)
-			}
-		}
-
-		if deepest.Owner != "" && deepest.Owner != currentUser {
-			cmd := fmt.Sprintf("chown %s %s", deepest.Owner, fullPath)
-			if deepest.NeedsElevation {
-				sudoChowns = append(sudoChowns, "sudo "+cmd)
-			} else {
-				chowns = append(chowns, cmd)
-			}
-		}
-
-		for _, c := range deepest.Children {
-			walkDirs(c)
+// describe returns what follows the name of the node in the tree: its type,
+// size and attributes.
+func (n *Node) describe() (suffix string) {
+	switch n.Type {
+	case TypeDirectory:
+		suffix += "/"
+	case TypeSymlink:
+		suffix += " -> " + n.Target
+	case TypeCharDevice, TypeBlockDevice:
+		suffix += fmt.Sprintf(" (%s %d:%d)", n.Type, n.Device.Major, n.Device.Minor)
+	case TypeFIFO, TypeSocket:
+		suffix += fmt.Sprintf(" (%s)", n.Type)
+	case TypeFile:
+		if n.Size > 0 {
+			suffix += fmt.Sprintf(" (%s, %s)", FormatSize(n.Size), n.Fill)
 		}
 	}
-	walkDirs(n)
-
-	var walkFiles func(node *Node)
-	walkFiles = func(node *Node) {
-		if node.Type == TypeFile {
-			fullPath := ExpandUserHome(node.BuildPathBackwards())
-
-			if node.Parent == nil {
-				return
-			}
-
-			if !utils.DoesPathExist(fullPath) {
-				cmd := fmt.Sprintf("touch %s", fullPath)
-				if node.NeedsElevation {
-					sudoTouches = append(sudoTouches, "sudo "+cmd)
-				} else {
-					touches = append(touches, cmd)
-				}
-			}
-
-			if node.Permission != utils.FilePerm {
-				cmd := fmt.Sprintf("chmod %o %s", node.Permission, fullPath)
-				if node.NeedsElevation {
-					sudoChmods = append(sudoChmods, "sudo "+cmd)
-				} else {
-					chmods = append(chmods, cmd)
-				}
-			}
-
-			if node.Owner != "" && node.Owner != currentUser {
-				cmd := fmt.Sprintf("chown %s %s", node.Owner, fullPath)
-				if node.NeedsElevation {
-					sudoChowns = append(sudoChowns, "sudo "+cmd)
-				} else {
-					chowns = append(chowns, cmd)
-				}
-			}
-			return
-		}
-		for _, c := range node.Children {
-			walkFiles(c)
-		}
-	}
-	walkFiles(n)
 
-	for _, cmd := range sudoMkdirs {
-		fmt.Println(cmd)
-	}
-	for _, cmd := range mkdirs {
-		fmt.Println(cmd)
+	if len(n.Xattrs) > 0 {
+		suffix += " [xattrs: " + strings.Join(n.xattrList(), " ") + "]"
 	}
-	for _, cmd := range sudoTouches {
-		fmt.Println(cmd)
+	if len(n.ACL) > 0 {
+		suffix += " [acl: " + strings.Join(n.ACL, ",") + "]"
 	}
-	for _, cmd := range touches {
-		fmt.Println(cmd)
-	}
-	for _, cmd := range sudoChmods {
-		fmt.Println(cmd)
-	}
-	for _, cmd := range chmods {
-		fmt.Println(cmd)
-	}
-	for _, cmd := range sudoChowns {
-		fmt.Println(cmd)
-	}
-	for _, cmd := range chowns {
-		fmt.Println(cmd)
+	return
+}
+
+func (n *Node) PrintCommands(dialect Dialect) error {
+	return WriteCommands(os.Stdout, n.Operations(), dialect)
+}
+
+// xattrList returns the extended attributes as sorted `name=value` pairs.
+func (n *Node) xattrList() []string {
+	list := make([]string, 0, len(n.Xattrs))
+	for name, value := range n.Xattrs {
+		list = append(list, name+"="+value)
 	}
+	slices.Sort(list)
+	return list
 }
 
-func (n *Node) PrintJSON(indent string) (string, error) {
-	bytes, err := json.MarshalIndent(n, "", indent)
+// PrintJSON returns the tree as a Document, nested or flat.
+func (n *Node) PrintJSON(indent string, flat bool) (string, error) {
+	bytes, err := json.MarshalIndent(n.Document(flat), "", indent)
 	if err != nil {
 		return "", err
 	}
@@ -2,9 +2,11 @@ package node
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type NodeInformation struct {
@@ -12,21 +14,93 @@ type NodeInformation struct {
 
 	Owner      string
 	Permission *os.FileMode
+	Type       *NodeType
+	Device     *Device
+	Size       *int64
+	Fill       FillMode
+	ModTime    *time.Time
+	Xattrs     map[string]string
+	ACL        []string
 }
 
-var ErrEmptyName = errors.New("name is empty")
+const (
+	markerOwner      = '@'
+	markerPermission = '%'
+	markerType       = '|'
+	markerSize       = '#'
+	markerTime       = '^'
+	markerAttributes = '&'
+)
+
+var markers = []byte{markerOwner, markerPermission, markerType, markerSize, markerTime}
 
+var (
+	ErrEmptyName   = errors.New("name is empty")
+	ErrUnknownType = errors.New("unknown node type")
+	ErrBadDevice   = errors.New("invalid device, expected c:<major>:<minor> or b:<major>:<minor>")
+
+	ErrSpecialDirectory = errors.New("only files can be fifos, sockets or devices")
+	ErrSizedNonFile     = errors.New("only regular files can have a size")
+)
+
+// ParsePathPart splits a part of a token into the name and what its markers
+// ask for. `|`, `#` and `^` are only markers when a valid type, size or time
+// follows them, and `&` when an attribute does, so names like `x|y`, `C#`,
+// `notes#1.txt`, `a^b` or `R&D` stay whole. The attributes run to the end of
+// the part.
 func ParsePathPart(part string) (*NodeInformation, error) {
 	info := new(NodeInformation)
+	now := time.Now()
+
+	part, attributes, hasAttributes := cutAttributes(part)
+
+	indexes := make(map[byte]int, len(markers))
+	for _, marker := range markers {
+		if index := strings.LastIndexByte(part, marker); index != -1 {
+			indexes[marker] = index
+		}
+	}
 
-	indexAt := strings.LastIndex(part, "@")
-	indexPercentage := strings.LastIndex(part, "%")
+	value := func(marker byte) (string, bool) {
+		start, ok := indexes[marker]
+		if !ok {
+			return "", false
+		}
+
+		end := len(part)
+		for _, index := range indexes {
+			if index > start && index < end {
+				end = index
+			}
+		}
+
+		return part[start+1 : end], true
+	}
+
+	// Markers without a valid value are part of whatever is before them.
+	// Dropping one makes the value before it longer, which may not be valid
+	// anymore either.
+	valid := []struct {
+		marker byte
+		check  func(string) bool
+	}{
+		{markerType, func(s string) bool { _, err := parseType(s); return err == nil }},
+		{markerSize, func(s string) bool { _, _, err := ParseSize(s); return err == nil }},
+		{markerTime, func(s string) bool { _, err := ParseTime(s, now); return err == nil || s == SourceDateEpoch }},
+	}
+	for dropped := true; dropped; {
+		dropped = false
+		for _, v := range valid {
+			if s, ok := value(v.marker); ok && !v.check(s) {
+				delete(indexes, v.marker)
+				dropped = true
+			}
+		}
+	}
 
 	endName := len(part)
-	if indexAt != -1 && (indexPercentage == -1 || indexAt < indexPercentage) {
-		endName = indexAt
-	} else if indexPercentage != -1 && (indexAt == -1 || indexPercentage < indexAt) {
-		endName = indexPercentage
+	for _, index := range indexes {
+		endName = min(endName, index)
 	}
 	info.Name = part[:endName]
 
@@ -34,34 +108,123 @@ func ParsePathPart(part string) (*NodeInformation, error) {
 		return nil, ErrEmptyName
 	}
 
-	if indexAt != -1 {
-		start := indexAt + 1
-		end := len(part)
+	if owner, ok := value(markerOwner); ok {
+		info.Owner = owner
+	}
 
-		if indexPercentage != -1 && indexPercentage > indexAt {
-			end = indexPercentage
+	if permissionString, ok := value(markerPermission); ok {
+		device := strings.TrimLeft(permissionString, "01234567")
+		permissionString = strings.TrimSuffix(permissionString, device)
+
+		if permissionString != "" || device == "" {
+			m, err := strconv.ParseUint(permissionString, 8, 12)
+			if err != nil {
+				return info, err
+			}
+			perm := fileMode(uint32(m))
+			info.Permission = &perm
 		}
 
-		info.Owner = part[start:end]
+		if device != "" {
+			nodeType, dev, err := parseDevice(device)
+			if err != nil {
+				return info, err
+			}
+			info.Type = &nodeType
+			info.Device = dev
+		}
 	}
 
-	if indexPercentage != -1 {
-		start := indexPercentage + 1
-		end := len(part)
+	if typeString, ok := value(markerType); ok {
+		nodeType, err := parseType(typeString)
+		if err != nil {
+			return info, err
+		}
+		if info.Type != nil && *info.Type != nodeType {
+			return info, fmt.Errorf("%w: %s conflicts with a %s", ErrUnknownType, typeString, info.Type)
+		}
+		info.Type = &nodeType
+	}
 
-		if indexAt != -1 && indexAt > indexPercentage {
-			end = indexAt
+	if sizeString, ok := value(markerSize); ok {
+		size, fill, err := ParseSize(sizeString)
+		if err != nil {
+			return info, err
 		}
+		info.Size = &size
+		info.Fill = fill
+	}
 
-		permissionString := part[start:end]
+	if timeString, ok := value(markerTime); ok {
+		t, err := ParseTime(timeString, now)
+		if err != nil {
+			return info, err
+		}
+		info.ModTime = &t
+	}
 
-		m, err := strconv.ParseUint(permissionString, 8, 32)
+	if hasAttributes {
+		xattrs, acl, err := parseAttributes(attributes)
 		if err != nil {
 			return info, err
 		}
-		perm := os.FileMode(m)
-		info.Permission = &perm
+		info.Xattrs = xattrs
+		info.ACL = acl
 	}
 
 	return info, nil
 }
+
+// cutAttributes splits part at the first `&` followed by a valid attribute,
+// returning what comes before it and the attribute list after it.
+func cutAttributes(part string) (before, attributes string, found bool) {
+	for i := 0; i < len(part); i++ {
+		if part[i] != markerAttributes {
+			continue
+		}
+
+		first := attributeItems(part[i+1:])[0]
+		if _, _, err := parseAttributeItems([]string{first}); err == nil {
+			return part[:i], part[i+1:], true
+		}
+	}
+	return part, "", false
+}
+
+func parseType(s string) (NodeType, error) {
+	switch s {
+	case "fifo", "pipe":
+		return TypeFIFO, nil
+	case "socket", "sock":
+		return TypeSocket, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownType, s)
+}
+
+func parseDevice(s string) (NodeType, *Device, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, nil, fmt.Errorf("%w: %q", ErrBadDevice, s)
+	}
+
+	var nodeType NodeType
+	switch parts[0] {
+	case "c":
+		nodeType = TypeCharDevice
+	case "b":
+		nodeType = TypeBlockDevice
+	default:
+		return 0, nil, fmt.Errorf("%w: %q", ErrBadDevice, s)
+	}
+
+	major, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %q", ErrBadDevice, s)
+	}
+	minor, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %q", ErrBadDevice, s)
+	}
+
+	return nodeType, &Device{Major: uint32(major), Minor: uint32(minor)}, nil
+}
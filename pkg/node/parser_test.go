@@ -0,0 +1,133 @@
+package node
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// parseCases are parts of tokens and what ParsePathPart makes of them.
+var parseCases = []struct {
+	part string
+	want NodeInformation
+}{
+	{"plain", NodeInformation{Name: "plain"}},
+	{"f%640@nobody", NodeInformation{Name: "f", Owner: "nobody", Permission: ptr(os.FileMode(0o640))}},
+
+	{"pipe|fifo", NodeInformation{Name: "pipe", Type: ptr(TypeFIFO)}},
+	{"sock|socket", NodeInformation{Name: "sock", Type: ptr(TypeSocket)}},
+	{"x|y", NodeInformation{Name: "x|y"}},
+	{"a|b|pipe", NodeInformation{Name: "a|b", Type: ptr(TypeFIFO)}},
+	{"null%0666c:1:3", NodeInformation{Name: "null", Permission: ptr(os.FileMode(0o666)), Type: ptr(TypeCharDevice), Device: &Device{Major: 1, Minor: 3}}},
+	{"sda%b:8:0", NodeInformation{Name: "sda", Type: ptr(TypeBlockDevice), Device: &Device{Major: 8, Minor: 0}}},
+
+	{"big.bin#10M", NodeInformation{Name: "big.bin", Size: ptr(int64(10 << 20)), Fill: FillSparse}},
+	{"noise#4KiB:random", NodeInformation{Name: "noise", Size: ptr(int64(4 << 10)), Fill: FillRandom}},
+	{"C#", NodeInformation{Name: "C#"}},
+	{"notes#1.txt", NodeInformation{Name: "notes#1.txt"}},
+	{"notes#1", NodeInformation{Name: "notes", Size: ptr(int64(1)), Fill: FillSparse}},
+	{"v#2#0:zero", NodeInformation{Name: "v#2", Size: ptr(int64(0)), Fill: FillZero}},
+
+	{"old^0@nobody", NodeInformation{Name: "old", Owner: "nobody", ModTime: ptr(time.Unix(0, 0))}},
+	{"day^2024-01-02", NodeInformation{Name: "day", ModTime: ptr(time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local))}},
+	{"a^b", NodeInformation{Name: "a^b"}},
+	{"x^y^1700000000", NodeInformation{Name: "x^y", ModTime: ptr(time.Unix(1700000000, 0))}},
+	{"f#1K^0", NodeInformation{Name: "f", Size: ptr(int64(1 << 10)), Fill: FillSparse, ModTime: ptr(time.Unix(0, 0))}},
+
+	{"R&D", NodeInformation{Name: "R&D"}},
+	{"R&D&user.team=rd", NodeInformation{Name: "R&D", Xattrs: map[string]string{"user.team": "rd"}}},
+	{`tags&user.tags=a\,b,user.by=me`, NodeInformation{Name: "tags", Xattrs: map[string]string{"user.tags": "a,b", "user.by": "me"}}},
+	{"note&user.note=a&b@c", NodeInformation{Name: "note", Xattrs: map[string]string{"user.note": "a&b@c"}}},
+	{"share%2770&d:g:dev:rx,u:nobody:6", NodeInformation{
+		Name:       "share",
+		Permission: ptr(os.ModeSetgid | 0o770),
+		ACL:        []string{"default:group:dev:r-x", "user:nobody:rw-"},
+	}},
+}
+
+func TestParsePathPart(t *testing.T) {
+	for _, c := range parseCases {
+		got, err := ParsePathPart(c.part)
+		if err != nil {
+			t.Errorf("%q: %v", c.part, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("%q: got %+v, want %+v", c.part, *got, c.want)
+		}
+	}
+}
+
+func TestParsePathPartErrors(t *testing.T) {
+	for _, c := range []struct {
+		part string
+		err  error
+	}{
+		{"@root", ErrEmptyName},
+		{"null%c:1", ErrBadDevice},
+		{"null%0666x:1:3", ErrBadDevice},
+		{"null%c:1:3|fifo", ErrUnknownType},
+	} {
+		if _, err := ParsePathPart(c.part); !errors.Is(err, c.err) {
+			t.Errorf("%q: got %v, want %v", c.part, err, c.err)
+		}
+	}
+}
+
+func TestParseSizeErrors(t *testing.T) {
+	for _, s := range []string{"", "K", "-1", "1.5M", "10X", "1G:dense", "1:", "9999999E"} {
+		if _, _, err := ParseSize(s); !errors.Is(err, ErrBadSize) {
+			t.Errorf("%q: got %v, want %v", s, err, ErrBadSize)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	t.Setenv(SourceDateEpoch, "1700000000")
+
+	for _, c := range []struct {
+		s    string
+		want time.Time
+	}{
+		{"1700000000", time.Unix(1700000000, 0)},
+		{"@-1", time.Unix(-1, 0)},
+		{"-3d", now.Add(-3 * 24 * time.Hour)},
+		{"+90m", now.Add(90 * time.Minute)},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{SourceDateEpoch, time.Unix(1700000000, 0)},
+	} {
+		got, err := ParseTime(c.s, now)
+		if err != nil {
+			t.Errorf("%q: %v", c.s, err)
+		} else if !got.Equal(c.want) {
+			t.Errorf("%q: got %s, want %s", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseTimeErrors(t *testing.T) {
+	for _, s := range []string{"", "yesterday", "+3y", "-d", "3d", "2024-13-01", "@"} {
+		if _, err := ParseTime(s, time.Now()); !errors.Is(err, ErrBadTime) {
+			t.Errorf("%q: got %v, want %v", s, err, ErrBadTime)
+		}
+	}
+
+	t.Setenv(SourceDateEpoch, "")
+	os.Unsetenv(SourceDateEpoch)
+	if _, err := ParseTime(SourceDateEpoch, time.Now()); !errors.Is(err, ErrBadTime) {
+		t.Errorf("unset %s: got %v, want %v", SourceDateEpoch, err, ErrBadTime)
+	}
+}
+
+func TestParseAttributesErrors(t *testing.T) {
+	for _, s := range []string{"", "user.=x", "bogus.k=v", "user.a=1,", "u:nobody:rwz", "x:nobody:rw", "m:nobody:rw", "d:u", "u:a:b:rw"} {
+		if _, _, err := parseAttributes(s); !errors.Is(err, ErrBadAttribute) {
+			t.Errorf("%q: got %v, want %v", s, err, ErrBadAttribute)
+		}
+	}
+}
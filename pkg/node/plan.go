@@ -0,0 +1,195 @@
+package node
+
+import (
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+type OperationKind int
+
+const (
+	OpMkdir OperationKind = iota
+	OpCreate
+	OpCopy
+	OpChmod
+	OpChown
+	OpSetXattr
+	OpSetACL
+	OpSetTime
+)
+
+func (k OperationKind) String() (name string) {
+	switch k {
+	case OpMkdir:
+		name = "mkdir"
+	case OpCreate:
+		name = "create"
+	case OpCopy:
+		name = "copy"
+	case OpChmod:
+		name = "chmod"
+	case OpChown:
+		name = "chown"
+	case OpSetXattr:
+		name = "setxattr"
+	case OpSetACL:
+		name = "setacl"
+	case OpSetTime:
+		name = "settime"
+	}
+	return
+}
+
+// Operation is a single step needed to bring the disk in line with the tree.
+// Everything but the extended attribute an OpSetXattr sets is read from Node.
+type Operation struct {
+	Kind     OperationKind
+	Path     string
+	Elevated bool
+	Node     *Node
+
+	Name  string
+	Value string
+}
+
+// Operations flattens the tree into the steps that create it. Steps are
+// grouped by kind in the order they must run and, within a kind, the ones
+// needing elevation come first. Collapsed directory chains are created with a
+// single OpMkdir of their deepest directory.
+func (n *Node) Operations() []Operation {
+	return n.operations(func(node *Node) bool { return node.disk().exists() })
+}
+
+// operations builds the plan, skipping the creation of nodes exists reports.
+func (n *Node) operations(exists func(node *Node) bool) []Operation {
+	currentUser := utils.CurrentUser
+
+	var phases [OpSetTime + 1]struct{ elevated, normal []Operation }
+	add := func(kind OperationKind, node *Node, path string) *Operation {
+		op := Operation{Kind: kind, Path: path, Elevated: node.NeedsElevation, Node: node}
+		phase := &phases[kind]
+		if op.Elevated {
+			phase.elevated = append(phase.elevated, op)
+			return &phase.elevated[len(phase.elevated)-1]
+		}
+		phase.normal = append(phase.normal, op)
+		return &phase.normal[len(phase.normal)-1]
+	}
+
+	var walkDirs func(node *Node)
+	walkDirs = func(node *Node) {
+		if node.Type != TypeDirectory {
+			return
+		}
+		if node.Parent != nil && node.Parent.Type == TypeDirectory && len(node.Parent.Children) == 1 {
+			return
+		}
+
+		_, deepest := node.Collapse()
+		if deepest.Type != TypeDirectory {
+			deepest = deepest.Up()
+		}
+		fullPath := ExpandUserHome(deepest.BuildPathBackwards())
+
+		if deepest.Parent == nil {
+			for _, c := range deepest.Children {
+				walkDirs(c)
+			}
+			return
+		}
+
+		if !exists(deepest) {
+			add(OpMkdir, deepest, fullPath)
+		}
+
+		if deepest.Permission != utils.DirPerm {
+			add(OpChmod, deepest, fullPath)
+		}
+
+		if deepest.Owner != "" && deepest.Owner != currentUser {
+			add(OpChown, deepest, fullPath)
+		}
+
+		for _, c := range deepest.Children {
+			walkDirs(c)
+		}
+	}
+	walkDirs(n)
+
+	var walkFiles func(node *Node)
+	walkFiles = func(node *Node) {
+		if node.Type != TypeDirectory {
+			fullPath := ExpandUserHome(node.BuildPathBackwards())
+
+			if node.Parent == nil {
+				return
+			}
+
+			if !exists(node) {
+				if node.Source != "" {
+					add(OpCopy, node, fullPath)
+				} else {
+					add(OpCreate, node, fullPath)
+				}
+			}
+
+			if node.Type != TypeSymlink && node.Permission != utils.FilePerm {
+				add(OpChmod, node, fullPath)
+			}
+
+			if node.Owner != "" && node.Owner != currentUser {
+				add(OpChown, node, fullPath)
+			}
+			return
+		}
+		for _, c := range node.Children {
+			walkFiles(c)
+		}
+	}
+	walkFiles(n)
+
+	var walkAttrs func(node *Node)
+	walkAttrs = func(node *Node) {
+		if node.Parent != nil && (len(node.Xattrs) > 0 || len(node.ACL) > 0) {
+			fullPath := ExpandUserHome(node.BuildPathBackwards())
+
+			for _, xattr := range node.xattrList() {
+				op := add(OpSetXattr, node, fullPath)
+				op.Name, op.Value, _ = strings.Cut(xattr, "=")
+			}
+			if len(node.ACL) > 0 {
+				add(OpSetACL, node, fullPath)
+			}
+		}
+
+		for _, c := range node.Children {
+			walkAttrs(c)
+		}
+	}
+	walkAttrs(n)
+
+	var walkTimes func(node *Node)
+	walkTimes = func(node *Node) {
+		for _, c := range node.Children {
+			walkTimes(c)
+		}
+
+		if node.ModTime == nil || node.Parent == nil {
+			return
+		}
+
+		fullPath := ExpandUserHome(node.BuildPathBackwards())
+		if !exists(node) {
+			add(OpSetTime, node, fullPath)
+		}
+	}
+	walkTimes(n)
+
+	ops := make([]Operation, 0)
+	for _, phase := range phases {
+		ops = append(ops, phase.elevated...)
+		ops = append(ops, phase.normal...)
+	}
+	return ops
+}
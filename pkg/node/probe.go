@@ -0,0 +1,150 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strconv"
+	"syscall"
+
+	"github.com/devkcud/mess/pkg/fsys"
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// probe is what is on disk at the path of a node: Lstat for symlinks, Stat
+// for everything else, and the target of symlinks.
+type probe struct {
+	info   fs.FileInfo
+	err    error
+	target string
+}
+
+// exists reports whether something is there. A parent that isn't a
+// directory leaves nothing there either, like in Status; other errors count
+// as existing, since something is in the way.
+func (p *probe) exists() bool {
+	return !errors.Is(p.err, fs.ErrNotExist) && !errors.Is(p.err, syscall.ENOTDIR)
+}
+
+// gid returns the group of what is there, when the platform reports one.
+func (p *probe) gid() (uint32, bool) {
+	if p.info == nil {
+		return 0, false
+	}
+	stat, ok := p.info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return stat.Gid, true
+}
+
+// Probe looks up on disk every node of the tree that wasn't yet, with one
+// stat per path, and fills in what planning leaves out: NeedsElevation and,
+// unless one was asked for, Owner. Existing paths get their owner on disk,
+// new ones root or the current user depending on whether their parent needs
+// elevation. Up to jobs paths are looked up at once.
+//
+// Planning doesn't touch the disk, so rendering and building go through Probe
+// first; mess.Plan does it in Tree.
+func (n *Node) Probe(jobs int) {
+	n = n.Root()
+
+	pending := make([]*Node, 0)
+	for _, node := range n.flatten() {
+		if node.probed == nil {
+			pending = append(pending, node)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	f := n.FS()
+	results := make([]probe, len(pending))
+	batch := &jobPool{ctx: context.Background(), limit: jobs, events: func(Event) {}}
+	batch.run(len(pending), func(i int, _ *step) error {
+		results[i] = stat(f, pending[i])
+		return nil
+	})
+
+	users := n.users()
+	owners := make(map[uint32]string)
+	ownerOf := func(info fs.FileInfo) string {
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return ""
+		}
+		if name, ok := owners[stat.Uid]; ok {
+			return name
+		}
+
+		name := ""
+		if u, err := users.LookupUserID(strconv.Itoa(int(stat.Uid))); err == nil {
+			name = u.Username
+		}
+		owners[stat.Uid] = name
+		return name
+	}
+
+	// Parents come before their children, so they are already probed.
+	for i, node := range pending {
+		p := &results[i]
+		node.probed = p
+
+		// The root keeps what New gave it.
+		if node.Parent == nil {
+			continue
+		}
+
+		owner := ""
+		switch {
+		case p.info != nil:
+			node.NeedsElevation = fsys.NeedsElevationStat(p.info)
+			owner = ownerOf(p.info)
+		case p.exists():
+			node.NeedsElevation = true
+		default:
+			node.NeedsElevation = node.Parent.NeedsElevation
+			owner = utils.CurrentUser
+			if node.NeedsElevation {
+				owner = utils.RootUser
+			}
+		}
+
+		if !node.ownerSet {
+			node.Owner = owner
+		}
+	}
+}
+
+func stat(f fsys.FS, n *Node) probe {
+	path := ExpandUserHome(n.BuildPathBackwards())
+
+	if n.Type != TypeSymlink {
+		info, err := f.Stat(path)
+		return probe{info: info, err: err}
+	}
+
+	info, err := f.Lstat(path)
+	p := probe{info: info, err: err}
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		p.target, _ = f.Readlink(path)
+	}
+	return p
+}
+
+// disk returns what is on disk at the path of n, probing the tree first if
+// n wasn't yet.
+func (n *Node) disk() *probe {
+	if n.probed == nil {
+		n.Probe(1)
+	}
+	return n.probed
+}
+
+// unprobe forgets what was found on disk for the whole tree, once it changed.
+func (n *Node) unprobe() {
+	for _, node := range n.Root().flatten() {
+		node.probed = nil
+	}
+}
@@ -0,0 +1,126 @@
+package node
+
+import (
+	_ "embed"
+	"time"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// SchemaVersion is bumped whenever a field of Entry changes meaning or goes
+// away. New optional fields don't bump it.
+const SchemaVersion = 1
+
+const SchemaID = "https://raw.githubusercontent.com/devkcud/mess/main/pkg/node/schema.json"
+
+// JSONSchema is the JSON Schema describing Document.
+//
+//go:embed schema.json
+var JSONSchema []byte
+
+// Document is the JSON output of mess. Root is set for the nested tree,
+// Entries for the flat list.
+type Document struct {
+	Schema  string   `json:"$schema"`
+	Version int      `json:"version"`
+	Root    *Entry   `json:"root,omitempty"`
+	Entries []*Entry `json:"entries,omitempty"`
+}
+
+// Entry is a node as described by the published schema.
+type Entry struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+
+	Owner          string `json:"owner"`
+	Group          string `json:"group"`
+	UID            *int   `json:"uid"`
+	GID            *int   `json:"gid"`
+	NeedsElevation bool   `json:"needs_elevation"`
+	Status         string `json:"status"`
+
+	Source string  `json:"source,omitempty"`
+	Target string  `json:"target,omitempty"`
+	Device *Device `json:"device,omitempty"`
+
+	Size int64    `json:"size,omitempty"`
+	Fill FillMode `json:"fill,omitempty"`
+
+	ModTime *time.Time `json:"mtime,omitempty"`
+
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	ACL    []string          `json:"acl,omitempty"`
+
+	Children []*Entry `json:"children,omitempty"`
+}
+
+var schemaTypes = map[NodeType]string{
+	TypeDirectory:   "directory",
+	TypeFile:        "file",
+	TypeSymlink:     "symlink",
+	TypeFIFO:        "fifo",
+	TypeSocket:      "socket",
+	TypeCharDevice:  "char-device",
+	TypeBlockDevice: "block-device",
+}
+
+// Entry describes the node alone, without its children.
+func (n *Node) Entry() *Entry {
+	e := &Entry{
+		Path:           ExpandUserHome(n.BuildPathBackwards()),
+		Name:           n.Name,
+		Type:           schemaTypes[n.Type],
+		Mode:           octalMode(n.Permission),
+		Owner:          n.Owner,
+		NeedsElevation: n.NeedsElevation,
+		Status:         n.Status().String(),
+		Source:         n.Source,
+		Target:         n.Target,
+		Device:         n.Device,
+		Size:           n.Size,
+		Fill:           n.Fill,
+		ModTime:        n.ModTime,
+		Xattrs:         n.Xattrs,
+		ACL:            n.ACL,
+	}
+
+	if uid, _, err := utils.LookupOwner(n.users(), n.Owner); err == nil {
+		e.UID = &uid
+	}
+	if group, gid, ok := n.groupOf(); ok {
+		e.Group, e.GID = group, &gid
+	}
+
+	return e
+}
+
+// Document describes the tree, either nested or as a flat list with
+// parents first.
+func (n *Node) Document(flat bool) *Document {
+	doc := &Document{Schema: SchemaID, Version: SchemaVersion}
+
+	var walk func(node *Node) *Entry
+	walk = func(node *Node) *Entry {
+		e := node.Entry()
+		if flat {
+			doc.Entries = append(doc.Entries, e)
+		}
+
+		for _, child := range node.Children {
+			c := walk(child)
+			if !flat {
+				e.Children = append(e.Children, c)
+			}
+		}
+		return e
+	}
+
+	root := walk(n)
+	if !flat {
+		doc.Root = root
+	}
+
+	return doc
+}
@@ -0,0 +1,137 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+const scriptHeader = `#!/bin/sh
+# Generated by mess. Run with --dry-run to print the commands instead.
+set -eu
+
+dry_run=false
+case "${1-}" in
+	-n | --dry-run) dry_run=true ;;
+esac
+
+die() {
+	printf 'mess: %s\n' "$*" >&2
+	exit 1
+}
+`
+
+const scriptRun = `run() {
+	if [ "$dry_run" = true ]; then
+		printf '%s\n' "$*"
+	else
+		"$@"
+	fi
+}
+`
+
+const scriptSudo = `sudo=sudo
+if [ "$dry_run" = true ] || [ "$(id -u)" -eq 0 ]; then
+	sudo=
+fi
+
+$sudo sh -eu -s -- "$dry_run" <<'%s'
+dry_run=$1
+`
+
+// PrintScript writes a standalone POSIX shell script that recreates the tree.
+func (n *Node) PrintScript() error {
+	return n.WriteScript(os.Stdout)
+}
+
+// WriteScript writes a standalone POSIX shell script that recreates the tree
+// on any machine. The script first checks every path for the same type
+// conflicts BuildFiles refuses, then runs everything needing elevation in a
+// single sudo section, then the rest. Creation steps are skipped for paths
+// that already exist when the script runs.
+func (n *Node) WriteScript(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(scriptHeader)
+	b.WriteString("\n")
+	b.WriteString(scriptRun)
+
+	b.WriteString("\n# Refuse to run over paths of the wrong type\n")
+	var check func(node *Node)
+	check = func(node *Node) {
+		if node.Parent != nil {
+			path := utils.ShellQuote(ExpandUserHome(node.BuildPathBackwards()))
+			if node.Type == TypeDirectory {
+				fmt.Fprintf(&b, "[ ! -e %s ] || [ -d %s ] || die %s\n", path, path, utils.ShellQuote(fmt.Sprintf("%v: %s", ErrNotDirectory, node.BuildPathBackwards())))
+			} else {
+				fmt.Fprintf(&b, "[ ! -d %s ] || die %s\n", path, utils.ShellQuote(fmt.Sprintf("%v: %s", ErrIsDirectory, node.BuildPathBackwards())))
+			}
+		}
+
+		for _, child := range node.Children {
+			check(child)
+		}
+	}
+	check(n)
+
+	var elevated, normal []Operation
+	for _, op := range n.operations(func(*Node) bool { return false }) {
+		if op.Elevated {
+			elevated = append(elevated, op)
+		} else {
+			normal = append(normal, op)
+		}
+	}
+
+	if len(elevated) > 0 {
+		var body strings.Builder
+		body.WriteString(scriptRun)
+		writeScriptOperations(&body, elevated)
+		delimiter := heredocDelimiter(body.String())
+
+		b.WriteString("\n")
+		fmt.Fprintf(&b, scriptSudo, delimiter)
+		b.WriteString(body.String())
+		b.WriteString(delimiter + "\n")
+	}
+
+	if len(normal) > 0 {
+		b.WriteString("\n")
+		writeScriptOperations(&b, normal)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeScriptOperations(b *strings.Builder, ops []Operation) {
+	for _, op := range ops {
+		line := "run " + quoteArgs(op.Args(), utils.ShellQuote)
+
+		switch op.Kind {
+		case OpMkdir, OpCreate, OpCopy:
+			path := utils.ShellQuote(op.Path)
+			line = fmt.Sprintf("[ -e %s ] || [ -h %s ] || %s", path, path, line)
+		}
+
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+}
+
+// heredocDelimiter returns a delimiter for a here-document holding body that
+// no line of body ends early, like one from a file name with newlines.
+func heredocDelimiter(body string) string {
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(body, "\n") {
+		lines[line] = true
+	}
+
+	delimiter := "MESS_SUDO"
+	for i := 1; lines[delimiter]; i++ {
+		delimiter = fmt.Sprintf("MESS_SUDO_%d", i)
+	}
+	return delimiter
+}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/devkcud/mess/pkg/fsys"
+)
+
+func TestWriteScriptHostileElevatedName(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run the script with")
+	}
+
+	hostile := NewWithFS("/srv", fsys.NewMem()).AddFile("evil\nMESS_SUDO\necho pwned")
+	tree := hostile.Root()
+	tree.Probe(1)
+	hostile.NeedsElevation = true
+
+	var script bytes.Buffer
+	if err := tree.WriteScript(&script); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(sh, "-c", script.String(), "sh", "--dry-run").CombinedOutput()
+	if err != nil {
+		t.Fatalf("script failed: %v\n%s", err, out)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "pwned" {
+			t.Fatalf("the file name ended the sudo section:\n%s", script.String())
+		}
+	}
+	if !strings.Contains(string(out), "echo pwned") {
+		t.Fatalf("the elevated file isn't created:\n%s", out)
+	}
+}
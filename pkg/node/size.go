@@ -0,0 +1,72 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type FillMode string
+
+const (
+	FillSparse FillMode = "sparse"
+	FillZero   FillMode = "zero"
+	FillRandom FillMode = "random"
+)
+
+var ErrBadSize = errors.New("invalid size, expected <n>[K|M|G|T][:sparse|:zero|:random]")
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseSize parses a size like `512`, `10M` or `1G:random`. Units are binary
+// and may be followed by `B` or `iB`. Files are sparse unless a fill mode is
+// given.
+func ParseSize(s string) (int64, FillMode, error) {
+	sizeString, fillString, hasFill := strings.Cut(s, ":")
+
+	fill := FillSparse
+	if hasFill {
+		switch FillMode(fillString) {
+		case FillSparse, FillZero, FillRandom:
+			fill = FillMode(fillString)
+		default:
+			return 0, "", fmt.Errorf("%w: %q", ErrBadSize, s)
+		}
+	}
+
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(sizeString), "B"), "I")
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > (1<<63-1)/factor {
+		return 0, "", fmt.Errorf("%w: %q", ErrBadSize, s)
+	}
+
+	return size * factor, fill, nil
+}
+
+// FormatSize renders size with the largest unit that divides it exactly.
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size != 0 && size%unit.factor == 0 {
+			return strconv.FormatInt(size/unit.factor, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
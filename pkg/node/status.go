@@ -0,0 +1,94 @@
+package node
+
+import (
+	"os"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// Status tells how a node relates to what is already on disk.
+type Status int
+
+const (
+	StatusNew Status = iota
+	StatusExists
+	StatusConflict
+	StatusChmod
+)
+
+func (s Status) String() (name string) {
+	switch s {
+	case StatusNew:
+		name = "new"
+	case StatusExists:
+		name = "exists"
+	case StatusConflict:
+		name = "conflict"
+	case StatusChmod:
+		name = "will-chmod"
+	}
+	return
+}
+
+// Status compares the node with its path on disk. Existing nodes of another
+// type, or symlinks pointing somewhere else, are conflicts. Existing nodes
+// with a mode other than the requested one are StatusChmod, the same way
+// Operations only changes modes that differ from the defaults.
+func (n *Node) Status() Status {
+	disk := n.disk()
+	info := disk.info
+	if disk.err != nil {
+		return StatusNew
+	}
+
+	if diskType(info) != n.Type {
+		return StatusConflict
+	}
+
+	switch n.Type {
+	case TypeSymlink:
+		if disk.target != n.Target {
+			return StatusConflict
+		}
+		return StatusExists
+	case TypeDirectory:
+		if n.Permission == utils.DirPerm {
+			return StatusExists
+		}
+	default:
+		if n.Permission == utils.FilePerm {
+			return StatusExists
+		}
+	}
+
+	if diskPermission(info) != n.Permission&modeBits {
+		return StatusChmod
+	}
+	return StatusExists
+}
+
+// diskType returns the NodeType of something found on disk.
+func diskType(info os.FileInfo) NodeType {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return TypeDirectory
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return TypeFIFO
+	case mode&os.ModeSocket != 0:
+		return TypeSocket
+	case mode&os.ModeCharDevice != 0:
+		return TypeCharDevice
+	case mode&os.ModeDevice != 0:
+		return TypeBlockDevice
+	}
+	return TypeFile
+}
+
+// diskPermission returns the mode bits of something found on disk in the
+// same form as Node.Permission.
+func diskPermission(info os.FileInfo) os.FileMode {
+	return info.Mode() & modeBits
+}
@@ -0,0 +1,224 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/devkcud/mess/pkg/utils"
+)
+
+// tmpfilesQuote quotes a tmpfiles.d field when it contains whitespace, quotes
+// or control characters, and doubles `%` so it isn't taken for a specifier.
+func tmpfilesQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '"' || r == '\'' || r == '\\' || r == 0x7f
+	}) == -1 {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// tmpfilesLines describes the planned nodes include accepts as tmpfiles.d(5)
+// lines. Nodes tmpfiles can't express are returned as comments. For the user
+// instance the owner columns are left to the invoking user when it owns the
+// node. Copies are copied from their source when they are created, unless
+// inlineCopies is set: then the content is written out in the line instead.
+func (n *Node) tmpfilesLines(include func(*Node) bool, userInstance, inlineCopies bool) ([]string, error) {
+	lines := make([]string, 0)
+
+	line := func(kind, path, mode, user, group, argument string) {
+		fields := []string{kind, tmpfilesQuote(path), mode, tmpfilesQuote(user), tmpfilesQuote(group), "-"}
+		if argument != "" {
+			fields = append(fields, tmpfilesQuote(argument))
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+
+	for _, node := range n.plannedNodes() {
+		if !include(node) {
+			continue
+		}
+
+		path := ExpandUserHome(node.BuildPathBackwards())
+		mode := octalMode(node.Permission)
+		user, group := node.Owner, node.group()
+		if user == "" || userInstance && user == utils.CurrentUser {
+			user, group = "-", "-"
+		}
+
+		switch node.Type {
+		case TypeDirectory:
+			line("d", path, mode, user, group, "")
+		case TypeSymlink:
+			line("L", path, "-", "-", "-", node.Target)
+		case TypeFIFO:
+			line("p", path, mode, user, group, "")
+		case TypeCharDevice, TypeBlockDevice:
+			kind := "c"
+			if node.Type == TypeBlockDevice {
+				kind = "b"
+			}
+			line(kind, path, mode, user, group, fmt.Sprintf("%d:%d", node.Device.Major, node.Device.Minor))
+		case TypeSocket:
+			lines = append(lines, "# tmpfiles.d cannot create sockets: "+path)
+		default:
+			if node.Source != "" && inlineCopies {
+				content, err := os.ReadFile(node.Source)
+				if err != nil {
+					return nil, err
+				}
+				line("f", path, mode, user, group, string(content))
+			} else if node.Source != "" {
+				line("C", path, "-", "-", "-", node.Source)
+				line("z", path, mode, user, group, "")
+			} else {
+				line("f", path, mode, user, group, "")
+				if node.Size > 0 {
+					lines = append(lines, fmt.Sprintf("# tmpfiles.d cannot size files, %s should be %s", path, FormatSize(node.Size)))
+				}
+			}
+		}
+
+		for _, xattr := range node.xattrList() {
+			line("t", path, "-", "-", "-", xattr)
+		}
+		if len(node.ACL) > 0 {
+			line("a+", path, "-", "-", "-", strings.Join(node.ACL, ","))
+		}
+	}
+
+	return lines, nil
+}
+
+// writeTmpfiles splits the lines between the system instance, for nodes that
+// need elevation, and the user instance for everything else.
+func (n *Node) writeTmpfiles(w io.Writer) error {
+	asRoot := os.Geteuid() == 0
+	system, err := n.tmpfilesLines(func(node *Node) bool { return asRoot || node.NeedsElevation }, false, false)
+	if err != nil {
+		return err
+	}
+	user, err := n.tmpfilesLines(func(node *Node) bool { return !asRoot && !node.NeedsElevation }, true, false)
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	if len(system) > 0 {
+		lines = append(lines, "# Needs root: systemd-tmpfiles --create <this file>")
+		lines = append(lines, system...)
+	}
+	if len(user) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "# As "+utils.CurrentUser+": systemd-tmpfiles --user --create <this file>")
+		lines = append(lines, user...)
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installArgs returns the install(1) command line that creates node at path.
+// The owner is only passed when it differs from the invoking user, the same
+// way echo mode only chowns in that case.
+func installArgs(node *Node, path string) [][]string {
+	mode := octalMode(node.Permission)
+
+	flags := []string{"-m", mode}
+	if node.Owner != "" && node.Owner != utils.CurrentUser {
+		flags = append(flags, "-o", node.Owner)
+		if group := node.group(); group != "-" {
+			flags = append(flags, "-g", group)
+		}
+	}
+
+	install := func(args ...string) []string {
+		command := []string{"install"}
+		if node.Type == TypeDirectory {
+			command = append(command, "-d")
+		}
+		command = append(command, flags...)
+		return append(append(command, "--"), args...)
+	}
+
+	switch {
+	case node.Type == TypeDirectory:
+		return [][]string{install(path)}
+	case node.Type == TypeFile && node.Source != "":
+		return [][]string{install(node.Source, path)}
+	case node.Type == TypeFile && node.Size > 0:
+		return [][]string{install("/dev/null", path), createArgs(node, path)}
+	case node.Type == TypeFile:
+		return [][]string{install("/dev/null", path)}
+	case node.Type == TypeSymlink:
+		return [][]string{{"ln", "-s", "--", node.Target, path}}
+	}
+
+	commands := [][]string{createArgs(node, path), {"chmod", mode, "--", path}}
+	if node.Owner != "" && node.Owner != utils.CurrentUser {
+		commands = append(commands, []string{"chown", node.Owner, "--", path})
+	}
+	return commands
+}
+
+// writeInstall creates every planned node with install(1), parents first, and
+// then sets attributes and timestamps. Commands for nodes needing elevation
+// run through sudo.
+func (n *Node) writeInstall(w io.Writer) error {
+	var lines []string
+	for _, node := range n.plannedNodes() {
+		path := ExpandUserHome(node.BuildPathBackwards())
+
+		for _, args := range installArgs(node, path) {
+			line := quoteArgs(args, utils.ShellQuote)
+			if node.NeedsElevation {
+				line = "sudo " + line
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	for _, op := range n.Operations() {
+		if !slices.Contains([]OperationKind{OpSetXattr, OpSetACL, OpSetTime}, op.Kind) {
+			continue
+		}
+
+		line := quoteArgs(op.Args(), utils.ShellQuote)
+		if op.Elevated {
+			line = "sudo " + line
+		}
+		lines = append(lines, line)
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
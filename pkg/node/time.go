@@ -0,0 +1,79 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+var ErrBadTime = errors.New("invalid time, expected a date, [@]<epoch>, [+-]<n><s|m|h|d|w> or SOURCE_DATE_EPOCH")
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var relativeUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// ParseTime parses an absolute date (RFC 3339 or `2006-01-02[ 15:04[:05]]` in
+// local time), a unix epoch with an optional `@`, a duration relative to now
+// such as `-3d`, or SOURCE_DATE_EPOCH to read the epoch from the environment.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == SourceDateEpoch {
+		epoch, ok := os.LookupEnv(SourceDateEpoch)
+		if !ok {
+			return time.Time{}, fmt.Errorf("%w: %s is not set", ErrBadTime, SourceDateEpoch)
+		}
+		s = epoch
+	}
+
+	if epoch, err := strconv.ParseInt(strings.TrimPrefix(s, "@"), 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+
+	if len(s) > 2 && (s[0] == '-' || s[0] == '+') {
+		if unit, ok := relativeUnits[s[len(s)-1]]; ok {
+			if n, err := strconv.ParseInt(s[1:len(s)-1], 10, 64); err == nil {
+				if s[0] == '-' {
+					n = -n
+				}
+				return now.Add(time.Duration(n) * unit), nil
+			}
+		}
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q", ErrBadTime, s)
+}
+
+// SetDefaultModTime sets t as the timestamp of every node in the tree that does
+// not exist yet and has no timestamp of its own.
+func (n *Node) SetDefaultModTime(t time.Time) {
+	if n.ModTime == nil && !n.disk().exists() {
+		n.ModTime = &t
+	}
+
+	for _, child := range n.Children {
+		child.SetDefaultModTime(t)
+	}
+}
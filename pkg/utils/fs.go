@@ -2,7 +2,6 @@ package utils
 
 import (
 	"os"
-	"os/user"
 	"strconv"
 	"strings"
 	"syscall"
@@ -34,31 +33,11 @@ func SplitPath(path string) []string {
 	return parts
 }
 
-func DoesPathExist(path string) bool {
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
-}
-
-func NeedsElevation(path string) bool {
-	if os.Geteuid() == 0 {
-		return false
-	}
-
-	if err := unix.Access(path, unix.W_OK); err == nil {
-		return false
-	} else if err == syscall.EACCES {
-		return true
-	} else {
-		return false
-	}
-}
-
-func GetOwnerInfo(path string) (uid uint32, username string) {
-	info, _ := os.Stat(path)
+func GetFileOwner(users Users, info os.FileInfo) (uid uint32, username string) {
 	stat := info.Sys().(*syscall.Stat_t)
 	uid = stat.Uid
 
-	u, err := user.LookupId(strconv.Itoa(int(uid)))
+	u, err := users.LookupUserID(strconv.Itoa(int(uid)))
 	if err != nil {
 		return 0, ""
 	}
@@ -66,3 +45,8 @@ func GetOwnerInfo(path string) (uid uint32, username string) {
 
 	return
 }
+
+func GetFileDevice(info os.FileInfo) (major, minor uint32) {
+	stat := info.Sys().(*syscall.Stat_t)
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev))
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "strings"
+
+func isShellSafe(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune("@%+=:,./_-", r)
+}
+
+// ShellQuote quotes s for a POSIX shell. Words made only of safe characters
+// are left alone, anything else is wrapped in single quotes.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	if strings.IndexFunc(s, func(r rune) bool { return !isShellSafe(r) }) == -1 {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// UseColor reports whether output written to f should be coloured: f has to
+// be a terminal and NO_COLOR must not be set.
+func UseColor(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	return term.IsTerminal(int(f.Fd()))
+}
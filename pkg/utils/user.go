@@ -1,6 +1,9 @@
 package utils
 
-import "os/user"
+import (
+	"os/user"
+	"strconv"
+)
 
 var RootUser = func() string {
 	u, err := user.LookupId("0")
@@ -17,3 +20,18 @@ var CurrentUser = func() string {
 	}
 	return u.Username
 }()
+
+// LookupOwner returns the uid and primary gid of username.
+func LookupOwner(users Users, username string) (uid, gid int, err error) {
+	u, err := users.LookupUser(username)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	uid, err = strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err = strconv.Atoi(u.Gid)
+	return uid, gid, err
+}
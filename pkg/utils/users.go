@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Users resolves user and group names and ids.
+type Users interface {
+	LookupUser(username string) (*user.User, error)
+	LookupUserID(uid string) (*user.User, error)
+	LookupGroup(name string) (*user.Group, error)
+	LookupGroupID(gid string) (*user.Group, error)
+}
+
+// HostUsers are the accounts of the running system.
+type HostUsers struct{}
+
+func (HostUsers) LookupUser(username string) (*user.User, error) { return user.Lookup(username) }
+func (HostUsers) LookupUserID(uid string) (*user.User, error)    { return user.LookupId(uid) }
+func (HostUsers) LookupGroup(name string) (*user.Group, error)   { return user.LookupGroup(name) }
+func (HostUsers) LookupGroupID(gid string) (*user.Group, error)  { return user.LookupGroupId(gid) }
+
+// FileUsers are the accounts of a passwd and a group file, like the ones of
+// a staged root. The files are read on the first lookup; missing files have
+// no accounts.
+type FileUsers struct {
+	passwdPath string
+	groupPath  string
+
+	once   sync.Once
+	users  []*user.User
+	groups []*user.Group
+}
+
+func NewFileUsers(passwdPath, groupPath string) *FileUsers {
+	return &FileUsers{passwdPath: passwdPath, groupPath: groupPath}
+}
+
+// readColonFile returns the fields of every entry of a passwd-style file.
+func readColonFile(path string) [][]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	entries := make([][]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries
+}
+
+func (f *FileUsers) load() {
+	f.once.Do(func() {
+		for _, fields := range readColonFile(f.passwdPath) {
+			if len(fields) < 7 {
+				continue
+			}
+			f.users = append(f.users, &user.User{
+				Username: fields[0],
+				Uid:      fields[2],
+				Gid:      fields[3],
+				Name:     strings.Split(fields[4], ",")[0],
+				HomeDir:  fields[5],
+			})
+		}
+
+		for _, fields := range readColonFile(f.groupPath) {
+			if len(fields) < 3 {
+				continue
+			}
+			f.groups = append(f.groups, &user.Group{Name: fields[0], Gid: fields[2]})
+		}
+	})
+}
+
+func (f *FileUsers) LookupUser(username string) (*user.User, error) {
+	f.load()
+	for _, u := range f.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, user.UnknownUserError(username)
+}
+
+func (f *FileUsers) LookupUserID(uid string) (*user.User, error) {
+	f.load()
+	for _, u := range f.users {
+		if u.Uid == uid {
+			return u, nil
+		}
+	}
+	id, _ := strconv.Atoi(uid)
+	return nil, user.UnknownUserIdError(id)
+}
+
+func (f *FileUsers) LookupGroup(name string) (*user.Group, error) {
+	f.load()
+	for _, g := range f.groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return nil, user.UnknownGroupError(name)
+}
+
+func (f *FileUsers) LookupGroupID(gid string) (*user.Group, error) {
+	f.load()
+	for _, g := range f.groups {
+		if g.Gid == gid {
+			return g, nil
+		}
+	}
+	return nil, user.UnknownGroupIdError(gid)
+}
+
+// CachedUsers remembers every lookup of Users, failed ones included, so
+// a tree with thousands of nodes looks each owner up once. It is safe for
+// concurrent use.
+type CachedUsers struct {
+	users Users
+
+	mu        sync.Mutex
+	byName    map[string]cachedUser
+	byUID     map[string]cachedUser
+	byGroup   map[string]cachedGroup
+	byGroupID map[string]cachedGroup
+}
+
+type cachedUser struct {
+	user *user.User
+	err  error
+}
+
+type cachedGroup struct {
+	group *user.Group
+	err   error
+}
+
+func NewCachedUsers(users Users) *CachedUsers {
+	return &CachedUsers{
+		users:     users,
+		byName:    make(map[string]cachedUser),
+		byUID:     make(map[string]cachedUser),
+		byGroup:   make(map[string]cachedGroup),
+		byGroupID: make(map[string]cachedGroup),
+	}
+}
+
+// lookup returns the cached result for key in cache, calling find and
+// storing its result the first time.
+func lookup[T any](c *CachedUsers, cache map[string]T, key string, find func() T) T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if result, ok := cache[key]; ok {
+		return result
+	}
+	result := find()
+	cache[key] = result
+	return result
+}
+
+func (c *CachedUsers) LookupUser(username string) (*user.User, error) {
+	r := lookup(c, c.byName, username, func() cachedUser {
+		u, err := c.users.LookupUser(username)
+		return cachedUser{u, err}
+	})
+	return r.user, r.err
+}
+
+func (c *CachedUsers) LookupUserID(uid string) (*user.User, error) {
+	r := lookup(c, c.byUID, uid, func() cachedUser {
+		u, err := c.users.LookupUserID(uid)
+		return cachedUser{u, err}
+	})
+	return r.user, r.err
+}
+
+func (c *CachedUsers) LookupGroup(name string) (*user.Group, error) {
+	r := lookup(c, c.byGroup, name, func() cachedGroup {
+		g, err := c.users.LookupGroup(name)
+		return cachedGroup{g, err}
+	})
+	return r.group, r.err
+}
+
+func (c *CachedUsers) LookupGroupID(gid string) (*user.Group, error) {
+	r := lookup(c, c.byGroupID, gid, func() cachedGroup {
+		g, err := c.users.LookupGroupID(gid)
+		return cachedGroup{g, err}
+	})
+	return r.group, r.err
+}